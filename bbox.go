@@ -0,0 +1,91 @@
+package main
+
+import "math"
+
+// computeBoundingBox returns the extent of all positions of the geometry as
+// [minx, miny, maxx, maxy], or as [minx, miny, minz, maxx, maxy, maxz] when all positions
+// have a third value. It returns nil when the geometry has no positions.
+func computeBoundingBox(g *Geometry) []float64 {
+	if g == nil {
+		return nil
+	}
+	var min, max []float64
+	g.eachPosition(func(p []float64) []float64 {
+		if len(p) < 2 {
+			return p
+		}
+		if min == nil {
+			min = append([]float64{}, p...)
+			max = append([]float64{}, p...)
+			return p
+		}
+		if len(p) < len(min) {
+			min, max = min[:len(p)], max[:len(p)]
+		}
+		for i := range min {
+			min[i] = math.Min(min[i], p[i])
+			max[i] = math.Max(max[i], p[i])
+		}
+		return p
+	})
+	if min == nil {
+		return nil
+	}
+	return append(min, max...)
+}
+
+// isValidBoundingBox checks the shape of a bbox as given in RFC 7946 section 5. A west
+// edge greater than the east edge is allowed as it denotes a box crossing the antimeridian.
+func isValidBoundingBox(bbox []float64) bool {
+	if len(bbox) != 4 && len(bbox) != 6 {
+		return false
+	}
+	if !allFinite(bbox) {
+		return false
+	}
+	dims := len(bbox) / 2
+	for i := 1; i < dims; i++ {
+		if bbox[i] > bbox[dims+i] {
+			return false
+		}
+	}
+	return true
+}
+
+// crossesAntimeridian is true for a bbox whose west edge is east of its east edge
+func crossesAntimeridian(bbox []float64) bool {
+	return bbox[0] > bbox[len(bbox)/2]
+}
+
+// mergeBoundingBoxes returns the extent covering all the given bboxes. A box crossing the
+// antimeridian widens the result to the full longitude range. The result only has a third
+// dimension when all the bboxes have one.
+func mergeBoundingBoxes(bboxes [][]float64) []float64 {
+	var min, max []float64
+	for _, bbox := range bboxes {
+		if !isValidBoundingBox(bbox) {
+			continue
+		}
+		dims := len(bbox) / 2
+		bmin := append([]float64{}, bbox[:dims]...)
+		bmax := append([]float64{}, bbox[dims:]...)
+		if crossesAntimeridian(bbox) {
+			bmin[0], bmax[0] = -180, 180
+		}
+		if min == nil {
+			min, max = bmin, bmax
+			continue
+		}
+		if dims < len(min) {
+			min, max = min[:dims], max[:dims]
+		}
+		for i := range min {
+			min[i] = math.Min(min[i], bmin[i])
+			max[i] = math.Max(max[i], bmax[i])
+		}
+	}
+	if min == nil {
+		return nil
+	}
+	return append(min, max...)
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+)
+
+func TestComputeBoundingBox(t *testing.T) {
+	tests := []struct {
+		wkt  string
+		bbox []float64
+	}{
+		{wkt: "POINT (10 60)", bbox: []float64{10, 60, 10, 60}},
+		{wkt: "LINESTRING (30 10, 10 30, 40 40)", bbox: []float64{10, 10, 40, 40}},
+		{wkt: "POLYGON Z ((0 0 1, 10 0 2, 10 10 3, 0 0 1))", bbox: []float64{0, 0, 1, 10, 10, 3}},
+		{wkt: "MULTIPOINT Z ((0 0 1), (5 5 2))", bbox: []float64{0, 0, 1, 5, 5, 2}},
+		// a position without height makes the whole bbox flat
+		{wkt: "GEOMETRYCOLLECTION (POINT Z (0 0 1), POINT (5 -5))", bbox: []float64{0, -5, 5, 0}},
+		{wkt: "POINT EMPTY"},
+		{wkt: "GEOMETRYCOLLECTION EMPTY"},
+	}
+	for _, test := range tests {
+		g, err := parseWKT(test.wkt)
+		if err != nil {
+			t.Fatalf("%s: %v", test.wkt, err)
+		}
+		if bbox := computeBoundingBox(g); !equalBoundingBoxes(bbox, test.bbox) {
+			t.Errorf("%s: got %v, expected %v", test.wkt, bbox, test.bbox)
+		}
+	}
+	if bbox := computeBoundingBox(nil); bbox != nil {
+		t.Errorf("a missing geometry has no bbox, got %v", bbox)
+	}
+}
+
+func TestIsValidBoundingBox(t *testing.T) {
+	tests := []struct {
+		bbox  []float64
+		valid bool
+	}{
+		{bbox: []float64{0, 0, 1, 1}, valid: true},
+		{bbox: []float64{0, 0, -5, 1, 1, 5}, valid: true},
+		{bbox: []float64{170, 0, -170, 1}, valid: true},
+		{bbox: []float64{0, 1, 1, 0}},
+		{bbox: []float64{0, 0, 5, 1, 1, -5}},
+		{bbox: []float64{0, 0, 1}},
+		{bbox: []float64{0, 0, math.NaN(), 1}},
+		{bbox: []float64{0, 0, math.Inf(1), 1}},
+		{bbox: nil},
+	}
+	for _, test := range tests {
+		if valid := isValidBoundingBox(test.bbox); valid != test.valid {
+			t.Errorf("%v: valid %v", test.bbox, valid)
+		}
+	}
+}
+
+func TestMergeBoundingBoxes(t *testing.T) {
+	tests := []struct {
+		name   string
+		bboxes [][]float64
+		bbox   []float64
+	}{
+		{name: "two boxes", bboxes: [][]float64{{0, 0, 1, 1}, {5, -5, 6, 0}}, bbox: []float64{0, -5, 6, 1}},
+		{name: "3D boxes", bboxes: [][]float64{{0, 0, -1, 1, 1, 0}, {5, 5, 2, 6, 6, 3}}, bbox: []float64{0, 0, -1, 6, 6, 3}},
+		{name: "a flat box makes the result flat", bboxes: [][]float64{{0, 0, -1, 1, 1, 0}, {5, 5, 6, 6}}, bbox: []float64{0, 0, 6, 6}},
+		{name: "invalid boxes are left out", bboxes: [][]float64{nil, {0, 1, 1, 0}, {2, 2, 3, 3}}, bbox: []float64{2, 2, 3, 3}},
+		{name: "a box crossing the antimeridian", bboxes: [][]float64{{170, 0, -170, 1}, {0, 5, 1, 6}}, bbox: []float64{-180, 0, 180, 6}},
+		{name: "no boxes"},
+	}
+	for _, test := range tests {
+		if bbox := mergeBoundingBoxes(test.bboxes); !equalBoundingBoxes(bbox, test.bbox) {
+			t.Errorf("%s: got %v, expected %v", test.name, bbox, test.bbox)
+		}
+	}
+}
+
+func TestFeatureBoundingBox(t *testing.T) {
+	tests := []struct {
+		name       string
+		axisOrder  string
+		properties map[string]any
+		bbox       []float64
+	}{
+		{
+			name:       "the extent of the geometry",
+			properties: map[string]any{flatgeoWktPredicate: "LINESTRING (10 60, 11 61)"},
+			bbox:       []float64{10, 60, 11, 61},
+		},
+		{
+			name:       "the bbox of the entity wins",
+			properties: map[string]any{flatgeoWktPredicate: "POINT (10 60)", flatgeoBboxPredicate: []any{9.0, 59.0, 11.0, 61.0}},
+			bbox:       []float64{9, 59, 11, 61},
+		},
+		{
+			name:       "an invalid bbox of the entity is replaced",
+			properties: map[string]any{flatgeoWktPredicate: "POINT (10 60)", flatgeoBboxPredicate: []any{11.0, 61.0, 9.0, 59.0, 1.0}},
+			bbox:       []float64{10, 60, 10, 60},
+		},
+		{
+			name:       "the bbox is in the axis order of the dataset",
+			axisOrder:  axisOrderLatLon,
+			properties: map[string]any{flatgeoWktPredicate: "POINT (60 10)", flatgeoBboxPredicate: []any{59.0, 9.0, 61.0, 11.0}},
+			bbox:       []float64{9, 59, 11, 61},
+		},
+		{
+			name:       "no geometry",
+			properties: map[string]any{},
+		},
+	}
+	for _, test := range tests {
+		ds := &Dataset{Name: "test", Type: "features", AxisOrder: test.axisOrder}
+		f := testFeature(ds, testEntity("1", test.properties))
+		if !equalBoundingBoxes(f.BoundingBox, test.bbox) {
+			t.Errorf("%s: got %v, expected %v", test.name, f.BoundingBox, test.bbox)
+		}
+	}
+}
+
+func TestCollectionBoundingBox(t *testing.T) {
+	ds := &Dataset{Name: "test", Type: "featurecollections", RemoteDataset: "test"}
+	members := []string{"http://data.example.org/a", "http://data.example.org/b"}
+	collection := testEntity("c", map[string]any{})
+	collection.References[rdfTypePredicate] = flatgeoFeatureCollection
+	collection.References[flatgeoFeaturesPredicate] = members
+	withBoundingBox := testEntity("d", map[string]any{flatgeoBboxPredicate: []any{-1.0, -1.0, 20.0, 20.0}})
+	withBoundingBox.References = collection.References
+
+	ec := NewEntityCollection()
+	ec.Entities = append(ec.Entities, collection, withBoundingBox,
+		testEntity("a", map[string]any{flatgeoWktPredicate: "POINT (0 0)"}),
+		testEntity("b", map[string]any{flatgeoWktPredicate: "POINT (10 5)"}))
+	collections, err := convertToFeatureCollections(ec, ds, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the members are given as their extent, unless the collection has a bbox of its own
+	expected := [][]float64{{0, 0, 10, 5}, {-1, -1, 20, 20}}
+	for i, bbox := range expected {
+		fc := collections[i+1].(*FeatureCollection)
+		if !equalBoundingBoxes(fc.BoundingBox, bbox) {
+			data, _ := json.Marshal(fc)
+			t.Errorf("%s: got %s, expected bbox %v", fc.Id, data, bbox)
+		}
+	}
+}
+
+func equalBoundingBoxes(a []float64, b []float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if math.Abs(a[i]-b[i]) > 1e-9 {
+			return false
+		}
+	}
+	return true
+}
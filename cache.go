@@ -0,0 +1,374 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// entityCacheRefreshInterval is how often a cache fetches the latest changes of its dataset
+// when all the entities asked for are in it
+const entityCacheRefreshInterval = time.Minute
+
+// errEntityCacheLoading is returned by lookups made while the cache is loaded for the first time
+var errEntityCacheLoading = errors.New("the entities are being loaded")
+
+// entityCache holds the latest version of all entities of a UDA dataset, such as the stations or
+// locations that features refer to for their geometry. It is loaded from the changes of the
+// dataset and kept up to date incrementally with the continuation token of the last load.
+// Loads are done one at a time and without holding the lock on the entities, so lookups carry on
+// with the entities already loaded. Ids that were not found are remembered for the refresh
+// interval, so that dangling references do not cause a refresh on every lookup.
+type entityCache struct {
+	remoteDataset string
+	mu            sync.RWMutex
+	entities      map[string]*Entity
+	// localNames are the ids of the entities by their local names
+	localNames map[string][]string
+	context    *Context
+	refreshed  time.Time
+	// absent are the ids not found, by when they were last looked for
+	absent map[string]time.Time
+	// loading is true while a refresh started in the background runs
+	loading bool
+
+	refreshMu sync.Mutex
+	// since is only used by refresh, under refreshMu
+	since string
+}
+
+var entityCaches sync.Map
+
+// lookupEntityCache returns the cache of the remote dataset, creating an empty one the first time
+func lookupEntityCache(remoteDataset string) *entityCache {
+	cache, _ := entityCaches.LoadOrStore(remoteDataset, &entityCache{
+		remoteDataset: remoteDataset,
+		entities:      make(map[string]*Entity),
+		localNames:    make(map[string][]string),
+		context:       NewContext(),
+		absent:        make(map[string]time.Time),
+	})
+	return cache.(*entityCache)
+}
+
+// getEntities returns the entities with the given ids found in the cache. The cache is refreshed
+// first when some of them are missing, unless they were not found in the last refresh interval.
+// When the last refresh is older than the refresh interval the cache is refreshed in the
+// background. Lookups made before the cache is loaded for the first time start the load in the
+// background and return errEntityCacheLoading, so that a page is not held up by reading a whole
+// dataset.
+func (c *entityCache) getEntities(ids []string) (map[string]*Entity, error) {
+	requested := time.Now()
+	c.mu.RLock()
+	missing := false
+	for _, id := range ids {
+		if _, found := c.entities[id]; !found && !c.isAbsent(id, requested) {
+			missing = true
+			break
+		}
+	}
+	loaded := !c.refreshed.IsZero()
+	stale := requested.Sub(c.refreshed) > entityCacheRefreshInterval
+	c.mu.RUnlock()
+
+	var err error
+	switch {
+	case !loaded:
+		c.refreshInBackground(requested)
+		err = errEntityCacheLoading
+	case missing:
+		err = c.refresh(requested)
+	case stale:
+		c.refreshInBackground(requested)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entities := make(map[string]*Entity)
+	for _, id := range ids {
+		if e, found := c.entities[id]; found {
+			entities[id] = e
+		} else if loaded && err == nil {
+			c.absent[id] = requested
+		}
+	}
+	return entities, err
+}
+
+// isAbsent is true when the id was not found in the last refresh interval, with the lock held
+func (c *entityCache) isAbsent(id string, now time.Time) bool {
+	absent, found := c.absent[id]
+	return found && now.Sub(absent) <= entityCacheRefreshInterval
+}
+
+// refreshInBackground starts a refresh unless one started in the background still runs
+func (c *entityCache) refreshInBackground(requested time.Time) {
+	c.mu.Lock()
+	if c.loading {
+		c.mu.Unlock()
+		return
+	}
+	c.loading = true
+	c.mu.Unlock()
+
+	go func() {
+		err := c.refresh(requested)
+		c.mu.Lock()
+		c.loading = false
+		c.mu.Unlock()
+		if err != nil {
+			log.Printf("unable to load the entities of %s: %v", c.remoteDataset, err)
+		}
+	}()
+}
+
+// refresh reads the changes since the last refresh, page by page, until there are no more. A
+// refresh that finished while waiting for another one to finish is enough for the request.
+func (c *entityCache) refresh(requested time.Time) error {
+	c.refreshMu.Lock()
+	defer c.refreshMu.Unlock()
+	c.mu.RLock()
+	refreshed := c.refreshed
+	c.mu.RUnlock()
+	if refreshed.After(requested) {
+		return nil
+	}
+
+	for {
+		ec, err := fetchChanges(c.remoteDataset, c.since, upstreamPageSize)
+		if err != nil {
+			return err
+		}
+		c.add(ec)
+		if ec.Continuation == nil || ec.Continuation.Token == "" {
+			break
+		}
+		c.since = ec.Continuation.Token
+		if len(ec.Entities) == 0 {
+			break
+		}
+	}
+	c.mu.Lock()
+	c.refreshed = time.Now()
+	for id, absent := range c.absent {
+		if c.refreshed.Sub(absent) > entityCacheRefreshInterval {
+			delete(c.absent, id)
+		}
+	}
+	c.mu.Unlock()
+	return nil
+}
+
+// add stores a page of changes in the cache
+func (c *entityCache) add(ec *EntityCollection) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if ec.Context != nil {
+		// the first mapping of a prefix wins, as the namespaces of a dataset rarely change
+		_ = c.context.Merge(ec.Context)
+	}
+	for _, e := range ec.Entities {
+		_, known := c.entities[e.ID]
+		switch {
+		case e.IsDeleted && known:
+			delete(c.entities, e.ID)
+			for _, name := range entityLocalNames(e.ID) {
+				c.localNames[name] = removeId(c.localNames[name], e.ID)
+				if len(c.localNames[name]) == 0 {
+					delete(c.localNames, name)
+				}
+			}
+		case !e.IsDeleted:
+			c.entities[e.ID] = e
+			delete(c.absent, e.ID)
+			if !known {
+				for _, name := range entityLocalNames(e.ID) {
+					c.localNames[name] = append(c.localNames[name], e.ID)
+				}
+			}
+		}
+	}
+}
+
+// entityLocalNames are the local names an entity can be found by: the end of its URI and the
+// part after the last colon, e.g. 116 for http://data.example.org/116 and urn:example:116
+func entityLocalNames(id string) []string {
+	names := []string{stripUrl(id)}
+	if i := strings.LastIndex(id, ":"); i >= 0 && id[i+1:] != names[0] {
+		names = append(names, id[i+1:])
+	}
+	return names
+}
+
+func removeId(ids []string, id string) []string {
+	for i, other := range ids {
+		if other == id {
+			return append(ids[:i:i], ids[i+1:]...)
+		}
+	}
+	return ids
+}
+
+// findEntity returns the latest version of an entity by its full URI, a CURIE with a prefix of
+// the dataset, an id relative to the namespace, or a local name that only one entity has. The
+// cache is refreshed first when the entity is not found, unless it was not found in the last
+// refresh interval, and in the background when the last refresh is older than the refresh
+// interval. Lookups made before the cache is loaded for the first time start the load in the
+// background and return errEntityCacheLoading, as a partly loaded cache may hold old versions.
+// It also returns a copy of the namespaces of the dataset, to name the properties of the entity.
+// The entity is nil when it is unknown or deleted.
+func (c *entityCache) findEntity(id string, namespace string) (*Entity, *Context, error) {
+	requested := time.Now()
+	c.mu.RLock()
+	e := c.lookup(id, namespace)
+	absent := e == nil && c.isAbsent(namespace+" "+id, requested)
+	loaded := !c.refreshed.IsZero()
+	stale := requested.Sub(c.refreshed) > entityCacheRefreshInterval
+	c.mu.RUnlock()
+
+	var err error
+	switch {
+	case !loaded:
+		c.refreshInBackground(requested)
+		return nil, nil, errEntityCacheLoading
+	case e == nil && !absent:
+		err = c.refresh(requested)
+	case stale:
+		c.refreshInBackground(requested)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e = c.lookup(id, namespace)
+	if e == nil && err == nil {
+		// ids are looked up in several forms, so they are remembered along with the namespace
+		c.absent[namespace+" "+id] = requested
+	}
+	context := NewContext()
+	_ = context.Merge(c.context)
+	return e, context, err
+}
+
+// lookup finds an entity as findEntity does, with the read lock held
+func (c *entityCache) lookup(id string, namespace string) *Entity {
+	if isFullURI(id) {
+		return c.entities[id]
+	}
+	if namespace != "" {
+		if e, found := c.entities[namespace+id]; found {
+			return e
+		}
+	}
+	if isCURIE, prefix, local := isCURIE(id); isCURIE {
+		if expansion, err := c.context.GetNamespaceExpansionForPrefix(prefix); err == nil {
+			if e, found := c.entities[expansion+local]; found {
+				return e
+			}
+		}
+	}
+
+	// a local name that is ambiguous finds nothing
+	if ids := c.localNames[id]; len(ids) == 1 {
+		return c.entities[ids[0]]
+	}
+	return nil
+}
+
+// readFeature returns the current version of a feature of the dataset by its id, as accepted by
+// findEntity, or nil when the feature is unknown or deleted. Collections of featurecollections
+// datasets are not features, their members are.
+func readFeature(ds *Dataset, id string, baseUrl string) (*Feature, error) {
+	e, context, err := lookupEntityCache(ds.RemoteDataset).findEntity(id, ds.IdNamespace)
+	if e == nil || isFeatureCollectionEntity(e) {
+		return nil, err
+	}
+	if err != nil {
+		log.Printf("dataset %s: unable to refresh the entities of %s: %v", ds.Name, ds.RemoteDataset, err)
+	}
+
+	ec := NewEntityCollection()
+	ec.Context = context
+	ec.Entities = append(ec.Entities, e)
+	batch := newFeatureBatch(ec, ds, baseUrl)
+	return makeFeature(e, ds, batch), nil
+}
+
+// geometryReference returns the id of the entity the entity refers to for its geometry
+func (ds *Dataset) geometryReference(e *Entity) (string, bool) {
+	if ds.GeometryReference == "" {
+		return "", false
+	}
+	for predicate := range e.References {
+		if matchesProperty(predicate, ds.GeometryReference) {
+			if ref, err := e.getReferenceValue(predicate); err == nil {
+				return ref, true
+			}
+		}
+	}
+	return "", false
+}
+
+// resolveGeometryEntities finds the entities referred to for their geometry by the entities of
+// the page. They are looked up in the page first and then, all at once, in the cache of the
+// dataset holding them, so that a page costs at most one incremental load of that dataset.
+func resolveGeometryEntities(ec *EntityCollection, ds *Dataset) map[string]*Entity {
+	if ds.GeometryReference == "" {
+		return nil
+	}
+
+	entitiesById := make(map[string]*Entity)
+	for _, e := range ec.Entities {
+		if !e.IsDeleted {
+			entitiesById[e.ID] = e
+		}
+	}
+
+	geometries := make(map[string]*Entity)
+	missing := make([]string, 0)
+	for _, e := range ec.Entities {
+		ref, found := ds.geometryReference(e)
+		if !found || e.IsDeleted {
+			continue
+		}
+		if target, inPage := entitiesById[ref]; inPage {
+			geometries[ref] = target
+		} else if _, seen := geometries[ref]; !seen {
+			geometries[ref] = nil
+			missing = append(missing, ref)
+		}
+	}
+	if len(missing) == 0 {
+		return geometries
+	}
+
+	remoteDataset := ds.GeometryDataset
+	if remoteDataset == "" {
+		remoteDataset = ds.RemoteDataset
+	}
+	cached, err := lookupEntityCache(remoteDataset).getEntities(missing)
+	if errors.Is(err, errEntityCacheLoading) {
+		ds.warnEvery("geometries loading", "features are published without the geometries of other entities while the entities of %s are loaded", remoteDataset)
+	} else if err != nil {
+		log.Printf("dataset %s: unable to load geometry entities from %s: %v", ds.Name, remoteDataset, err)
+	}
+	for _, ref := range missing {
+		if target, found := cached[ref]; found {
+			geometries[ref] = target
+		} else if err == nil {
+			ds.warnEvery("geometry reference", "entity %s holding a geometry is not found in %s", ref, remoteDataset)
+		}
+	}
+	return geometries
+}
+
+// geometryEntity is the entity holding the geometry of the entity, which is the entity itself
+// unless it refers to another one. It is nil when the referenced entity is not found.
+func (b *featureBatch) geometryEntity(e *Entity, ds *Dataset) *Entity {
+	ref, found := ds.geometryReference(e)
+	if !found {
+		return e
+	}
+	return b.geometries[ref]
+}
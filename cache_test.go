@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+)
+
+func TestEntityCache(t *testing.T) {
+	hub := &fakeDatahub{datasets: map[string][]string{
+		"stations": {`{"http://data.example.org/name":"a"}`, `{"http://data.example.org/name":"b"}`},
+	}}
+	serveDatahub(t, hub)
+	cache := lookupEntityCache("stations")
+
+	// the first lookup starts the load without waiting for it
+	_, err := cache.getEntities([]string{"http://data.example.org/0"})
+	if !errors.Is(err, errEntityCacheLoading) {
+		t.Fatalf("the first lookup should not wait for the load, got %v", err)
+	}
+	waitForEntityCache(t, cache)
+
+	entities, err := cache.getEntities([]string{"http://data.example.org/0", "http://data.example.org/1"})
+	if err != nil || len(entities) != 2 {
+		t.Fatalf("got %d entities, %v", len(entities), err)
+	}
+
+	// a dangling id is looked for once and then remembered as absent
+	requests := atomic.LoadInt32(&hub.requests)
+	for i := 0; i < 3; i++ {
+		entities, err = cache.getEntities([]string{"http://data.example.org/1", "http://data.example.org/missing"})
+		if err != nil || len(entities) != 1 {
+			t.Fatalf("got %d entities, %v", len(entities), err)
+		}
+	}
+	if extra := atomic.LoadInt32(&hub.requests) - requests; extra != 1 {
+		t.Errorf("a missing id should cause one refresh, got %d requests", extra)
+	}
+
+	for i := 0; i < 3; i++ {
+		e, _, err := cache.findEntity("missing", "http://data.example.org/")
+		if e != nil || err != nil {
+			t.Fatalf("got %v, %v for a missing feature", e, err)
+		}
+	}
+	if extra := atomic.LoadInt32(&hub.requests) - requests; extra != 2 {
+		t.Errorf("a missing feature should cause one refresh, got %d requests", extra-1)
+	}
+	if e, _, _ := cache.findEntity("1", ""); e == nil || e.ID != "http://data.example.org/1" {
+		t.Errorf("the entity should be found by its local name, got %v", e)
+	}
+}
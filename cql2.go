@@ -0,0 +1,1077 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// CQL2 filters, as in OGC API - Features Part 3, in the text and the JSON encoding. Both are
+// parsed into the same expression tree, which is evaluated against each feature.
+
+const (
+	filterLangText = "cql2-text"
+	filterLangJSON = "cql2-json"
+)
+
+// cqlExpression is a node of a CQL2 expression. Literals have no operator and hold a string,
+// float64, bool, *cqlTime or *Geometry value, or nil for NULL. Properties have the operator
+// "property" and their name as value. Lists have the operator "list" and their items as
+// arguments. All other operators and functions are lower case, e.g. "and", "<=", "like",
+// "s_intersects" and "t_during".
+type cqlExpression struct {
+	Op    string
+	Args  []*cqlExpression
+	Value any
+	// mapping is the property mapping of a property, if it has one
+	mapping *PropertyMap
+	// pattern is the compiled pattern of a like with a literal pattern
+	pattern *regexp.Regexp
+	// parts are the decomposed geometry of a geometry literal
+	parts *geometryParts
+}
+
+// cqlTime is an instant or an interval. A date covers the whole day and a zero start or end
+// is an open end.
+type cqlTime struct {
+	start time.Time
+	end   time.Time
+}
+
+var cqlComparisonOps = map[string]bool{"=": true, "<>": true, "<": true, "<=": true, ">": true, ">=": true}
+
+var cqlSpatialOps = map[string]bool{"s_intersects": true, "s_disjoint": true, "s_within": true, "s_contains": true}
+
+var cqlTemporalOps = map[string]bool{
+	"t_after": true, "t_before": true, "t_contains": true, "t_disjoint": true, "t_during": true,
+	"t_equals": true, "t_finishedby": true, "t_finishes": true, "t_intersects": true, "t_meets": true,
+	"t_metby": true, "t_overlappedby": true, "t_overlaps": true, "t_startedby": true, "t_starts": true,
+}
+
+// parseCQLFilter parses a filter in the given language, cql2-text when it is empty, and
+// returns it as a feature filter. Geometry literals are in the CRS named by filterCrs, or in
+// CRS84 when it is empty.
+func parseCQLFilter(filter string, lang string, filterCrs string, ds *Dataset) (featureFilter, error) {
+	var x *cqlExpression
+	var err error
+	switch strings.ToLower(lang) {
+	case "", filterLangText:
+		x, err = parseCQLText(filter, ds)
+	case filterLangJSON:
+		var v any
+		if err := json.Unmarshal([]byte(filter), &v); err != nil {
+			return nil, fmt.Errorf("filter is not JSON: %w", err)
+		}
+		x, err = parseCQLJSON(v, ds)
+	default:
+		return nil, errors.New("filter-lang must be cql2-text or cql2-json")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter: %w", err)
+	}
+	if !x.isPredicate() {
+		return nil, errors.New("invalid filter: not a condition")
+	}
+
+	if filterCrs != "" {
+		crs, err := lookupCRS(filterCrs)
+		if err != nil {
+			return nil, err
+		}
+		x.eachGeometry(func(g *Geometry) {
+			g.eachPosition(crs.toWGS84FromAxisOrder)
+		})
+	}
+	if err := x.prepare(); err != nil {
+		return nil, fmt.Errorf("invalid filter: %w", err)
+	}
+	return x.test, nil
+}
+
+// -------------  CQL2 text ------------- //
+
+type cqlToken struct {
+	kind  string // ident, quoted, string, number, op, punct or eof
+	text  string
+	start int
+}
+
+type cqlTextParser struct {
+	input string
+	pos   int
+	ds    *Dataset
+}
+
+func parseCQLText(input string, ds *Dataset) (*cqlExpression, error) {
+	p := &cqlTextParser{input: input, ds: ds}
+	x, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != "eof" {
+		return nil, fmt.Errorf("unexpected %s at %d", t.text, t.start)
+	}
+	return x, nil
+}
+
+func (p *cqlTextParser) next() cqlToken {
+	for p.pos < len(p.input) && unicode.IsSpace(rune(p.input[p.pos])) {
+		p.pos++
+	}
+	start := p.pos
+	if p.pos >= len(p.input) {
+		return cqlToken{kind: "eof", text: "end of filter", start: start}
+	}
+
+	c := p.input[p.pos]
+	switch {
+	case c == '(' || c == ')' || c == ',':
+		p.pos++
+		return cqlToken{kind: "punct", text: string(c), start: start}
+	case c == '=':
+		p.pos++
+		return cqlToken{kind: "op", text: "=", start: start}
+	case c == '<' || c == '>':
+		p.pos++
+		if p.pos < len(p.input) && (p.input[p.pos] == '=' || c == '<' && p.input[p.pos] == '>') {
+			p.pos++
+		}
+		return cqlToken{kind: "op", text: p.input[start:p.pos], start: start}
+	case c == '\'' || c == '"':
+		// quotes are escaped by doubling them
+		var b strings.Builder
+		p.pos++
+		for p.pos < len(p.input) {
+			if p.input[p.pos] == c {
+				if p.pos+1 < len(p.input) && p.input[p.pos+1] == c {
+					b.WriteByte(c)
+					p.pos += 2
+					continue
+				}
+				p.pos++
+				kind := "string"
+				if c == '"' {
+					kind = "quoted"
+				}
+				return cqlToken{kind: kind, text: b.String(), start: start}
+			}
+			b.WriteByte(p.input[p.pos])
+			p.pos++
+		}
+		return cqlToken{kind: "error", text: "unterminated quote", start: start}
+	case c == '-' || c == '+' || c == '.' || c >= '0' && c <= '9':
+		p.pos++
+		for p.pos < len(p.input) && strings.IndexByte("0123456789.eE", p.input[p.pos]) >= 0 ||
+			p.pos < len(p.input) && (p.input[p.pos] == '-' || p.input[p.pos] == '+') && (p.input[p.pos-1] == 'e' || p.input[p.pos-1] == 'E') {
+			p.pos++
+		}
+		return cqlToken{kind: "number", text: p.input[start:p.pos], start: start}
+	case isCQLIdentifierRune(rune(c)):
+		for p.pos < len(p.input) && isCQLIdentifierRune(rune(p.input[p.pos])) {
+			p.pos++
+		}
+		return cqlToken{kind: "ident", text: p.input[start:p.pos], start: start}
+	}
+	p.pos++
+	return cqlToken{kind: "error", text: string(c), start: start}
+}
+
+func isCQLIdentifierRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == ':' || r == '.' || r >= 0x80
+}
+
+func (p *cqlTextParser) peek() cqlToken {
+	pos := p.pos
+	t := p.next()
+	p.pos = pos
+	return t
+}
+
+// keyword is true when the next token is the keyword, which is then consumed
+func (p *cqlTextParser) keyword(keyword string) bool {
+	t := p.peek()
+	if t.kind == "ident" && strings.EqualFold(t.text, keyword) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *cqlTextParser) expect(text string) error {
+	t := p.next()
+	if t.kind == "punct" && t.text == text {
+		return nil
+	}
+	return fmt.Errorf("expected %s at %d, found %s", text, t.start, t.text)
+}
+
+func (p *cqlTextParser) parseOr() (*cqlExpression, error) {
+	x, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("OR") {
+		y, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		x = &cqlExpression{Op: "or", Args: []*cqlExpression{x, y}}
+		if err := x.checkArguments(); err != nil {
+			return nil, err
+		}
+	}
+	return x, nil
+}
+
+func (p *cqlTextParser) parseAnd() (*cqlExpression, error) {
+	x, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("AND") {
+		y, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		x = &cqlExpression{Op: "and", Args: []*cqlExpression{x, y}}
+		if err := x.checkArguments(); err != nil {
+			return nil, err
+		}
+	}
+	return x, nil
+}
+
+func (p *cqlTextParser) parseNot() (*cqlExpression, error) {
+	if p.keyword("NOT") {
+		x, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		not := &cqlExpression{Op: "not", Args: []*cqlExpression{x}}
+		return not, not.checkArguments()
+	}
+	return p.parsePredicate()
+}
+
+func (p *cqlTextParser) parsePredicate() (*cqlExpression, error) {
+	if t := p.peek(); t.kind == "punct" && t.text == "(" {
+		p.next()
+		x, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return x, p.expect(")")
+	}
+
+	if t := p.peek(); t.kind == "ident" {
+		op := strings.ToLower(t.text)
+		if cqlSpatialOps[op] || cqlTemporalOps[op] {
+			p.next()
+			args, err := p.parseArguments()
+			if err != nil {
+				return nil, err
+			}
+			if len(args) != 2 {
+				return nil, fmt.Errorf("%s takes two arguments", t.text)
+			}
+			return &cqlExpression{Op: op, Args: args}, nil
+		}
+	}
+
+	x, err := p.parseScalar()
+	if err != nil {
+		return nil, err
+	}
+
+	t := p.peek()
+	if t.kind == "op" {
+		p.next()
+		y, err := p.parseScalar()
+		if err != nil {
+			return nil, err
+		}
+		return &cqlExpression{Op: t.text, Args: []*cqlExpression{x, y}}, nil
+	}
+
+	negate := p.keyword("NOT")
+	var predicate *cqlExpression
+	switch {
+	case p.keyword("LIKE"):
+		pattern, err := p.parseScalar()
+		if err != nil {
+			return nil, err
+		}
+		predicate = &cqlExpression{Op: "like", Args: []*cqlExpression{x, pattern}}
+	case p.keyword("BETWEEN"):
+		low, err := p.parseScalar()
+		if err != nil {
+			return nil, err
+		}
+		if !p.keyword("AND") {
+			return nil, errors.New("expected AND in BETWEEN")
+		}
+		high, err := p.parseScalar()
+		if err != nil {
+			return nil, err
+		}
+		predicate = &cqlExpression{Op: "between", Args: []*cqlExpression{x, low, high}}
+	case p.keyword("IN"):
+		items, err := p.parseArguments()
+		if err != nil {
+			return nil, err
+		}
+		predicate = &cqlExpression{Op: "in", Args: []*cqlExpression{x, {Op: "list", Args: items}}}
+	case !negate && p.keyword("IS"):
+		negate = p.keyword("NOT")
+		if !p.keyword("NULL") {
+			return nil, errors.New("expected NULL after IS")
+		}
+		predicate = &cqlExpression{Op: "isnull", Args: []*cqlExpression{x}}
+	case negate:
+		return nil, fmt.Errorf("expected LIKE, BETWEEN or IN after NOT at %d", p.peek().start)
+	default:
+		// a boolean literal or property
+		return x, nil
+	}
+	if negate {
+		return &cqlExpression{Op: "not", Args: []*cqlExpression{predicate}}, nil
+	}
+	return predicate, nil
+}
+
+// parseArguments reads a parenthesized, comma separated list of scalars
+func (p *cqlTextParser) parseArguments() ([]*cqlExpression, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	args := make([]*cqlExpression, 0)
+	for {
+		x, err := p.parseScalar()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, x)
+		t := p.next()
+		if t.kind == "punct" && t.text == ")" {
+			return args, nil
+		}
+		if t.kind != "punct" || t.text != "," {
+			return nil, fmt.Errorf("expected , or ) at %d, found %s", t.start, t.text)
+		}
+	}
+}
+
+func (p *cqlTextParser) parseScalar() (*cqlExpression, error) {
+	t := p.next()
+	switch t.kind {
+	case "string":
+		return &cqlExpression{Value: t.text}, nil
+	case "number":
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %s", t.text)
+		}
+		return &cqlExpression{Value: f}, nil
+	case "quoted":
+		return makeCQLProperty(t.text, p.ds)
+	case "ident":
+	default:
+		return nil, fmt.Errorf("unexpected %s at %d", t.text, t.start)
+	}
+
+	word := strings.ToUpper(t.text)
+	switch word {
+	case "TRUE", "FALSE":
+		return &cqlExpression{Value: word == "TRUE"}, nil
+	case "NULL":
+		return &cqlExpression{}, nil
+	}
+
+	if _, isGeometry := wktGeometryTypes[word]; isGeometry {
+		return p.parseGeometryLiteral(t.start)
+	}
+
+	// functions
+	if next := p.peek(); next.kind == "punct" && next.text == "(" {
+		switch word {
+		case "DATE", "TIMESTAMP":
+			args, err := p.parseArguments()
+			if err != nil {
+				return nil, err
+			}
+			if len(args) != 1 {
+				return nil, fmt.Errorf("%s takes one argument", word)
+			}
+			return makeCQLInstant(args[0].Value, word == "DATE")
+		case "INTERVAL":
+			args, err := p.parseArguments()
+			if err != nil {
+				return nil, err
+			}
+			if len(args) != 2 {
+				return nil, errors.New("INTERVAL takes two arguments")
+			}
+			return makeCQLInterval(args[0], args[1])
+		case "BBOX":
+			args, err := p.parseArguments()
+			if err != nil {
+				return nil, err
+			}
+			bbox := make([]any, 0, len(args))
+			for _, arg := range args {
+				bbox = append(bbox, arg.Value)
+			}
+			return makeCQLBoundingBox(bbox)
+		case "CASEI":
+			args, err := p.parseArguments()
+			if err != nil {
+				return nil, err
+			}
+			if len(args) != 1 {
+				return nil, errors.New("CASEI takes one argument")
+			}
+			return &cqlExpression{Op: "casei", Args: args}, nil
+		}
+		return nil, fmt.Errorf("unsupported function %s", t.text)
+	}
+	return makeCQLProperty(t.text, p.ds)
+}
+
+// parseGeometryLiteral hands the WKT text starting at start to the WKT parser
+func (p *cqlTextParser) parseGeometryLiteral(start int) (*cqlExpression, error) {
+	end := p.pos
+	for end < len(p.input) && p.input[end] != '(' {
+		if strings.HasPrefix(strings.ToUpper(p.input[end:]), "EMPTY") {
+			end += len("EMPTY")
+			break
+		}
+		end++
+	}
+	if end < len(p.input) && p.input[end] == '(' {
+		depth := 0
+		for ; end < len(p.input); end++ {
+			if p.input[end] == '(' {
+				depth++
+			} else if p.input[end] == ')' {
+				depth--
+				if depth == 0 {
+					end++
+					break
+				}
+			}
+		}
+	}
+	g, err := parseWKT(p.input[start:end])
+	if err != nil {
+		return nil, err
+	}
+	p.pos = end
+	return &cqlExpression{Value: g}, nil
+}
+
+// -------------  CQL2 JSON ------------- //
+
+func parseCQLJSON(v any, ds *Dataset) (*cqlExpression, error) {
+	switch value := v.(type) {
+	case nil, string, float64, bool:
+		return &cqlExpression{Value: value}, nil
+	case []any:
+		items := make([]*cqlExpression, 0, len(value))
+		for _, item := range value {
+			x, err := parseCQLJSON(item, ds)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, x)
+		}
+		return &cqlExpression{Op: "list", Args: items}, nil
+	case map[string]any:
+		return parseCQLJSONObject(value, ds)
+	}
+	return nil, fmt.Errorf("unexpected %v", v)
+}
+
+func parseCQLJSONObject(object map[string]any, ds *Dataset) (*cqlExpression, error) {
+	if op, ok := object["op"].(string); ok {
+		args, _ := object["args"].([]any)
+		x := &cqlExpression{Op: strings.ToLower(op)}
+		for _, arg := range args {
+			a, err := parseCQLJSON(arg, ds)
+			if err != nil {
+				return nil, err
+			}
+			x.Args = append(x.Args, a)
+		}
+		return x, x.checkArguments()
+	}
+	if name, ok := object["property"].(string); ok {
+		return makeCQLProperty(name, ds)
+	}
+	if date, ok := object["date"]; ok {
+		return makeCQLInstant(date, true)
+	}
+	if timestamp, ok := object["timestamp"]; ok {
+		return makeCQLInstant(timestamp, false)
+	}
+	if interval, ok := object["interval"].([]any); ok && len(interval) == 2 {
+		start, err := parseCQLJSON(interval[0], ds)
+		if err != nil {
+			return nil, err
+		}
+		end, err := parseCQLJSON(interval[1], ds)
+		if err != nil {
+			return nil, err
+		}
+		return makeCQLInterval(start, end)
+	}
+	if bbox, ok := object["bbox"].([]any); ok {
+		return makeCQLBoundingBox(bbox)
+	}
+	if geometryType, ok := object["type"].(string); ok {
+		g, err := makeCQLGeoJSONGeometry(geometryType, object)
+		if err != nil {
+			return nil, err
+		}
+		return &cqlExpression{Value: g}, nil
+	}
+	return nil, errors.New("unknown JSON object in filter")
+}
+
+func makeCQLGeoJSONGeometry(geometryType string, object map[string]any) (*Geometry, error) {
+	if geometryType != "GeometryCollection" {
+		return makeGeometry(geometryType, object["coordinates"], 2)
+	}
+	members, _ := object["geometries"].([]any)
+	g := &Geometry{Type: geometryType, Geometries: make([]*Geometry, 0, len(members))}
+	for _, m := range members {
+		member, ok := m.(map[string]any)
+		if !ok {
+			return nil, errors.New("geometry collection members must be geometries")
+		}
+		memberType, _ := member["type"].(string)
+		mg, err := makeCQLGeoJSONGeometry(memberType, member)
+		if err != nil {
+			return nil, err
+		}
+		g.Geometries = append(g.Geometries, mg)
+	}
+	return g, nil
+}
+
+// checkArguments checks the number of arguments of an operator, and that the arguments of the
+// logical operators are conditions
+func (x *cqlExpression) checkArguments() error {
+	if x.Op == "and" || x.Op == "or" || x.Op == "not" {
+		for _, arg := range x.Args {
+			if !arg.isPredicate() {
+				return fmt.Errorf("the arguments of %s must be conditions", x.Op)
+			}
+		}
+	}
+	count := -1
+	switch {
+	case x.Op == "and" || x.Op == "or":
+		if len(x.Args) < 2 {
+			return fmt.Errorf("%s takes at least two arguments", x.Op)
+		}
+		return nil
+	case x.Op == "not" || x.Op == "isnull" || x.Op == "casei":
+		count = 1
+	case cqlComparisonOps[x.Op] || cqlSpatialOps[x.Op] || cqlTemporalOps[x.Op] || x.Op == "like" || x.Op == "in":
+		count = 2
+	case x.Op == "between":
+		count = 3
+	default:
+		return fmt.Errorf("unsupported operator %s", x.Op)
+	}
+	if len(x.Args) != count {
+		return fmt.Errorf("%s takes %d arguments", x.Op, count)
+	}
+	if x.Op == "in" && x.Args[1].Op != "list" {
+		return errors.New("the second argument of in must be a list")
+	}
+	return nil
+}
+
+// -------------  literals ------------- //
+
+// makeCQLProperty refers to a property of the feature. Besides the queryable properties of the
+// dataset a filter may use id, geometry, and datetime or time for the time of the feature.
+func makeCQLProperty(name string, ds *Dataset) (*cqlExpression, error) {
+	switch name {
+	case "id", "geometry", "datetime", "time":
+		return &cqlExpression{Op: "property", Value: name}, nil
+	}
+	m, queryable := ds.lookupQueryable(name)
+	if !queryable {
+		return nil, fmt.Errorf("unknown property %s, the queryable properties are %s", name, strings.Join(ds.knownQueryables(), ", "))
+	}
+	return &cqlExpression{Op: "property", Value: name, mapping: m}, nil
+}
+
+func makeCQLInstant(value any, dateOnly bool) (*cqlExpression, error) {
+	s, ok := value.(string)
+	if !ok {
+		return nil, fmt.Errorf("invalid time %v", value)
+	}
+	if dateOnly && len(strings.TrimSpace(s)) != len("2006-01-02") {
+		return nil, fmt.Errorf("invalid date %s", s)
+	}
+	start, end, err := parseDatetimeBound(s)
+	if err != nil {
+		return nil, err
+	}
+	return &cqlExpression{Value: &cqlTime{start: start, end: end}}, nil
+}
+
+// makeCQLInterval builds an interval from two instants, given as strings or time literals,
+// where ".." is an open end
+func makeCQLInterval(startArg *cqlExpression, endArg *cqlExpression) (*cqlExpression, error) {
+	interval := &cqlTime{}
+	for i, arg := range []*cqlExpression{startArg, endArg} {
+		var bound *cqlTime
+		switch v := arg.Value.(type) {
+		case string:
+			if v == ".." {
+				continue
+			}
+			instant, err := makeCQLInstant(v, false)
+			if err != nil {
+				return nil, err
+			}
+			bound = instant.Value.(*cqlTime)
+		case *cqlTime:
+			bound = v
+		default:
+			return nil, errors.New("interval bounds must be times")
+		}
+		if i == 0 {
+			interval.start = bound.start
+		} else {
+			interval.end = bound.end
+		}
+	}
+	return &cqlExpression{Value: interval}, nil
+}
+
+// makeCQLBoundingBox turns a bbox literal into a polygon
+func makeCQLBoundingBox(values []any) (*cqlExpression, error) {
+	bbox := make([]float64, 0, len(values))
+	for _, v := range values {
+		f, ok := v.(float64)
+		if !ok {
+			return nil, errors.New("bbox values must be numbers")
+		}
+		bbox = append(bbox, f)
+	}
+	if !isValidBoundingBox(bbox) || crossesAntimeridian(bbox) {
+		return nil, errors.New("bbox must have 4 or 6 numbers, minimum first")
+	}
+	dims := len(bbox) / 2
+	minx, miny, maxx, maxy := bbox[0], bbox[1], bbox[dims], bbox[dims+1]
+	g := &Geometry{Type: "Polygon", Coordinates: []interface{}{
+		[][]float64{{minx, miny}, {maxx, miny}, {maxx, maxy}, {minx, maxy}, {minx, miny}},
+	}}
+	return &cqlExpression{Value: g}, nil
+}
+
+// -------------  evaluation ------------- //
+
+// isPredicate is true for expressions that evaluate to a boolean
+func (x *cqlExpression) isPredicate() bool {
+	switch {
+	case x.Op == "" || x.Op == "property":
+		_, isBool := x.Value.(bool)
+		return isBool || x.Op == "property"
+	case x.Op == "list" || x.Op == "casei":
+		return false
+	}
+	return true
+}
+
+func (x *cqlExpression) eachGeometry(fn func(g *Geometry)) {
+	if g, ok := x.Value.(*Geometry); ok {
+		fn(g)
+	}
+	for _, arg := range x.Args {
+		arg.eachGeometry(fn)
+	}
+}
+
+// isLiteral is true when the value of the expression does not depend on the feature
+func (x *cqlExpression) isLiteral() bool {
+	switch x.Op {
+	case "":
+		return true
+	case "list", "casei":
+		for _, arg := range x.Args {
+			if !arg.isLiteral() {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// prepare compiles the literal patterns of likes and decomposes the geometry literals, so that
+// this is done once rather than for every feature tested. It must be called after the geometries
+// are transformed to WGS84.
+func (x *cqlExpression) prepare() error {
+	for _, arg := range x.Args {
+		if err := arg.prepare(); err != nil {
+			return err
+		}
+	}
+	switch {
+	case x.Op == "like" && x.Args[1].isLiteral():
+		pattern, ok := x.Args[1].value(nil).(string)
+		if !ok {
+			return errors.New("the pattern of like must be a string")
+		}
+		re, err := likePattern(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid like pattern %q: %w", pattern, err)
+		}
+		x.pattern = re
+	case x.Op == "":
+		if g, ok := x.Value.(*Geometry); ok {
+			x.parts = decomposeGeometry(g)
+		}
+	}
+	return nil
+}
+
+// geometryParts are the decomposed geometry value of the expression, if it is one
+func (x *cqlExpression) geometryParts(f *Feature) (*geometryParts, bool) {
+	if x.parts != nil {
+		return x.parts, true
+	}
+	g, ok := x.value(f).(*Geometry)
+	if !ok {
+		return nil, false
+	}
+	return decomposeGeometry(g), true
+}
+
+// test evaluates a predicate against the feature. Comparisons with missing values are false.
+func (x *cqlExpression) test(f *Feature) bool {
+	switch x.Op {
+	case "and":
+		for _, arg := range x.Args {
+			if !arg.test(f) {
+				return false
+			}
+		}
+		return true
+	case "or":
+		for _, arg := range x.Args {
+			if arg.test(f) {
+				return true
+			}
+		}
+		return false
+	case "not":
+		return !x.Args[0].test(f)
+	case "isnull":
+		return x.Args[0].value(f) == nil
+	case "like":
+		re := x.pattern
+		if re == nil {
+			pattern, ok := x.Args[1].value(f).(string)
+			if !ok {
+				return false
+			}
+			var err error
+			if re, err = likePattern(pattern); err != nil {
+				return false
+			}
+		}
+		return anyValue(x.Args[0].value(f), func(v any) bool {
+			s, ok := v.(string)
+			return ok && re.MatchString(s)
+		})
+	case "between":
+		low, high := x.Args[1].value(f), x.Args[2].value(f)
+		return anyValue(x.Args[0].value(f), func(v any) bool {
+			c1, ok1 := compareCQLValues(v, low)
+			c2, ok2 := compareCQLValues(v, high)
+			return ok1 && ok2 && c1 >= 0 && c2 <= 0
+		})
+	case "in":
+		items := x.Args[1].value(f).([]any)
+		return anyValue(x.Args[0].value(f), func(v any) bool {
+			for _, item := range items {
+				if c, ok := compareCQLValues(v, item); ok && c == 0 {
+					return true
+				}
+			}
+			return false
+		})
+	case "", "property":
+		b, _ := x.value(f).(bool)
+		return b
+	}
+
+	if cqlComparisonOps[x.Op] {
+		right := x.Args[1].value(f)
+		return anyValue(x.Args[0].value(f), func(v any) bool {
+			c, ok := compareCQLValues(v, right)
+			if !ok {
+				return x.Op == "<>" && v != nil && right != nil
+			}
+			switch x.Op {
+			case "=":
+				return c == 0
+			case "<>":
+				return c != 0
+			case "<":
+				return c < 0
+			case "<=":
+				return c <= 0
+			case ">":
+				return c > 0
+			}
+			return c >= 0
+		})
+	}
+
+	if cqlSpatialOps[x.Op] {
+		a, okA := x.Args[0].geometryParts(f)
+		b, okB := x.Args[1].geometryParts(f)
+		if !okA || !okB {
+			return false
+		}
+		switch x.Op {
+		case "s_intersects":
+			return partsIntersect(a, b)
+		case "s_disjoint":
+			return !partsIntersect(a, b)
+		case "s_within":
+			return partsWithin(a, b)
+		case "s_contains":
+			return partsWithin(b, a)
+		}
+	}
+
+	if cqlTemporalOps[x.Op] {
+		a, okA := toCQLTime(x.Args[0].value(f))
+		b, okB := toCQLTime(x.Args[1].value(f))
+		return okA && okB && temporalRelation(x.Op, a, b)
+	}
+	return false
+}
+
+// value evaluates a scalar expression against the feature
+func (x *cqlExpression) value(f *Feature) any {
+	switch x.Op {
+	case "":
+		return x.Value
+	case "list":
+		items := make([]any, 0, len(x.Args))
+		for _, arg := range x.Args {
+			items = append(items, arg.value(f))
+		}
+		return items
+	case "casei":
+		v := x.Args[0].value(f)
+		if s, ok := v.(string); ok {
+			return strings.ToLower(s)
+		}
+		if list, ok := v.([]any); ok {
+			lowered := make([]any, 0, len(list))
+			for _, item := range list {
+				if s, ok := item.(string); ok {
+					item = strings.ToLower(s)
+				}
+				lowered = append(lowered, item)
+			}
+			return lowered
+		}
+		return v
+	case "property":
+		name := x.Value.(string)
+		switch name {
+		case "id":
+			return f.Id
+		case "geometry":
+			if f.Geometry == nil {
+				return nil
+			}
+			return f.Geometry
+		case "datetime", "time":
+			if f.Time != nil {
+				return &cqlTime{start: f.start, end: f.end}
+			}
+			if !f.recorded.IsZero() {
+				return &cqlTime{start: f.recorded, end: f.recorded}
+			}
+			return nil
+		}
+		v, found := featurePropertyValue(f, name, x.mapping)
+		if !found {
+			return nil
+		}
+		if list, ok := v.([]string); ok {
+			values := make([]any, 0, len(list))
+			for _, item := range list {
+				values = append(values, item)
+			}
+			return values
+		}
+		return v
+	}
+	return x.test(f)
+}
+
+// anyValue applies the test to the value, or to each value of a multi valued property
+func anyValue(v any, test func(v any) bool) bool {
+	if list, ok := v.([]any); ok {
+		for _, item := range list {
+			if test(item) {
+				return true
+			}
+		}
+		return false
+	}
+	return test(v)
+}
+
+// compareCQLValues orders two values. Times are compared by their start, numbers as numbers
+// and strings as strings. It is false when the values can not be compared.
+func compareCQLValues(a any, b any) (int, bool) {
+	if a == nil || b == nil {
+		return 0, false
+	}
+	_, aIsTime := a.(*cqlTime)
+	_, bIsTime := b.(*cqlTime)
+	if aIsTime || bIsTime {
+		ta, okA := toCQLTime(a)
+		tb, okB := toCQLTime(b)
+		if !okA || !okB {
+			return 0, false
+		}
+		return compareFloats(float64(ta.start.UnixNano()), float64(tb.start.UnixNano())), true
+	}
+
+	switch av := a.(type) {
+	case float64, int64:
+		an, _ := toNumber(av)
+		bn, err := toNumber(b)
+		if err != nil {
+			return 0, false
+		}
+		return compareFloats(an, bn), true
+	case string:
+		switch bv := b.(type) {
+		case string:
+			return strings.Compare(av, bv), true
+		case float64:
+			an, err := toNumber(av)
+			if err != nil {
+				return 0, false
+			}
+			return compareFloats(an, bv), true
+		}
+	case bool:
+		if bv, ok := b.(bool); ok {
+			if av == bv {
+				return 0, true
+			}
+			return 1, true
+		}
+	}
+	return 0, false
+}
+
+// toCQLTime converts a time literal, or a property holding a date or an RFC 3339 time
+func toCQLTime(v any) (*cqlTime, bool) {
+	switch t := v.(type) {
+	case *cqlTime:
+		return t, true
+	case string:
+		start, end, err := parseDatetimeBound(t)
+		if err != nil {
+			return nil, false
+		}
+		return &cqlTime{start: start, end: end}, true
+	}
+	return nil, false
+}
+
+// endOfTime stands in for the open end of an interval
+var endOfTime = time.Date(9999, 12, 31, 23, 59, 59, 0, time.UTC)
+
+// temporalRelation tests the relation of the temporal functions of CQL2 between a and b
+func temporalRelation(op string, a *cqlTime, b *cqlTime) bool {
+	as, ae, bs, be := a.start, a.end, b.start, b.end
+	if ae.IsZero() {
+		ae = endOfTime
+	}
+	if be.IsZero() {
+		be = endOfTime
+	}
+	switch op {
+	case "t_after":
+		return as.After(be)
+	case "t_before":
+		return ae.Before(bs)
+	case "t_contains":
+		return as.Before(bs) && ae.After(be)
+	case "t_disjoint":
+		return as.After(be) || ae.Before(bs)
+	case "t_during":
+		return as.After(bs) && ae.Before(be)
+	case "t_equals":
+		return as.Equal(bs) && ae.Equal(be)
+	case "t_finishedby":
+		return as.Before(bs) && ae.Equal(be)
+	case "t_finishes":
+		return as.After(bs) && ae.Equal(be)
+	case "t_intersects":
+		return !as.After(be) && !ae.Before(bs)
+	case "t_meets":
+		return ae.Equal(bs)
+	case "t_metby":
+		return as.Equal(be)
+	case "t_overlappedby":
+		return as.After(bs) && as.Before(be) && ae.After(be)
+	case "t_overlaps":
+		return as.Before(bs) && ae.After(bs) && ae.Before(be)
+	case "t_startedby":
+		return as.Equal(bs) && ae.After(be)
+	case "t_starts":
+		return as.Equal(bs) && ae.Before(be)
+	}
+	return false
+}
+
+// likePattern turns a LIKE pattern, with % for any characters, _ for one character and \ as
+// escape, into a regular expression
+func likePattern(pattern string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("(?s)^")
+	escaped := false
+	for _, r := range pattern {
+		switch {
+		case escaped:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '%':
+			b.WriteString(".*")
+		case r == '_':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCQLFilter(t *testing.T) {
+	ds := &Dataset{Name: "jellyfish", PropertyMapping: &PropertyMapping{
+		Include: []string{"Species", "Name", "Note"},
+		Mappings: []*PropertyMap{
+			{Property: "Quantity", Type: "int"},
+			{Property: "Active", Type: "bool"},
+		},
+	}}
+	point, err := parseWKT("POINT (10 60)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2018, 2, 12, 0, 0, 0, 0, time.UTC)
+	f := &Feature{
+		Id:       "http://data.example.org/1",
+		Geometry: point,
+		Time:     &FeatureTime{},
+		start:    start,
+		end:      start.AddDate(0, 0, 1).Add(-time.Nanosecond),
+		Properties: map[string]interface{}{
+			"Quantity": int64(100),
+			"Active":   true,
+			"Name":     "Rhopilema Nomadica",
+			"Species":  []any{"Rhopilema nomadica", "Aurelia aurita"},
+		},
+	}
+
+	tests := []struct {
+		filter    string
+		lang      string
+		filterCrs string
+		matches   bool
+	}{
+		// comparisons
+		{filter: "Quantity = 100", matches: true},
+		{filter: "Quantity <> 100"},
+		{filter: "Quantity > 10 AND Quantity <= 100", matches: true},
+		{filter: "Quantity < 10 OR Active", matches: true},
+		{filter: "NOT Active"},
+		{filter: "Active = true", matches: true},
+		{filter: "Species = 'Aurelia aurita'", matches: true},
+		{filter: "Species <> 'Aurelia aurita'", matches: true},
+		{filter: "\"Quantity\" >= 100", matches: true},
+		{filter: "id = 'http://data.example.org/1'", matches: true},
+		// missing values only match IS NULL
+		{filter: "Note = 'x'"},
+		{filter: "Note <> 'x'"},
+		{filter: "Note IS NULL", matches: true},
+		{filter: "Name IS NOT NULL", matches: true},
+		// like, between, in and casei
+		{filter: "Name LIKE 'Rhop%'", matches: true},
+		{filter: "Name LIKE 'rhop%'"},
+		{filter: "CASEI(Name) LIKE CASEI('rhop%')", matches: true},
+		{filter: "Name LIKE 'Rhopilema_Nomadica'", matches: true},
+		{filter: "Name LIKE 'Rhopilema\\_Nomadica'"},
+		{filter: "Name NOT LIKE '%a'"},
+		{filter: "Species LIKE 'Aurelia%'", matches: true},
+		{filter: "Quantity BETWEEN 50 AND 100", matches: true},
+		{filter: "Quantity NOT BETWEEN 50 AND 100"},
+		{filter: "Quantity IN (1, 100)", matches: true},
+		{filter: "Quantity NOT IN (1, 100)"},
+		{filter: "CASEI(Name) IN (CASEI('RHOPILEMA NOMADICA'))", matches: true},
+		// spatial
+		{filter: "S_INTERSECTS(geometry, POINT (10 60))", matches: true},
+		{filter: "S_INTERSECTS(geometry, BBOX(0, 50, 20, 70))", matches: true},
+		{filter: "S_DISJOINT(geometry, BBOX(0, 50, 5, 55))", matches: true},
+		{filter: "S_WITHIN(geometry, POLYGON ((0 50, 20 50, 20 70, 0 70, 0 50)))", matches: true},
+		{filter: "S_CONTAINS(geometry, POINT (10 60))", matches: true},
+		{filter: "S_CONTAINS(geometry, LINESTRING (10 60, 11 60))"},
+		{filter: "S_INTERSECTS(geometry, POINT (60 10))", filterCrs: "EPSG:4326", matches: true},
+		// temporal
+		{filter: "T_INTERSECTS(datetime, DATE('2018-02-12'))", matches: true},
+		{filter: "T_BEFORE(datetime, TIMESTAMP('2018-02-13T00:00:00Z'))", matches: true},
+		{filter: "T_AFTER(datetime, DATE('2018-02-12'))"},
+		{filter: "T_DURING(datetime, INTERVAL('2018-01-01T00:00:00Z', '..'))", matches: true},
+		{filter: "T_EQUALS(datetime, DATE('2018-02-12'))", matches: true},
+		// json
+		{filter: `{"op":"=","args":[{"property":"Quantity"},100]}`, lang: "cql2-json", matches: true},
+		{filter: `{"op":"like","args":[{"property":"Name"},"%Nomadica"]}`, lang: "cql2-json", matches: true},
+		{filter: `{"op":"in","args":[{"property":"Quantity"},[1,2]]}`, lang: "cql2-json"},
+		{filter: `{"op":"s_intersects","args":[{"property":"geometry"},{"type":"Point","coordinates":[10,60]}]}`, lang: "cql2-json", matches: true},
+		{filter: `{"op":"s_intersects","args":[{"property":"geometry"},{"bbox":[11,50,20,70]}]}`, lang: "cql2-json"},
+		{filter: `{"op":"t_intersects","args":[{"property":"datetime"},{"interval":["2018-02-12T12:00:00Z",".."]}]}`, lang: "cql2-json", matches: true},
+		{filter: `{"op":"and","args":[{"op":"isnull","args":[{"property":"Note"}]},{"property":"Active"}]}`, lang: "cql2-json", matches: true},
+	}
+	for _, test := range tests {
+		filter, err := parseCQLFilter(test.filter, test.lang, test.filterCrs, ds)
+		if err != nil {
+			t.Errorf("%s: %v", test.filter, err)
+			continue
+		}
+		if matches := filter(f); matches != test.matches {
+			t.Errorf("%s: matches %v", test.filter, matches)
+		}
+	}
+
+	invalid := []struct {
+		filter string
+		lang   string
+	}{
+		{filter: ""},
+		{filter: "Quantity = "},
+		{filter: "Colour = 'red'"},
+		{filter: "Quantity = 1 AND"},
+		{filter: "(Quantity = 1"},
+		{filter: "Name NOT 'x'"},
+		{filter: "Name LIKE 5"},
+		{filter: "Quantity BETWEEN 1 OR 2"},
+		{filter: "S_INTERSECTS(geometry)"},
+		{filter: "S_INTERSECTS(geometry, BBOX(20, 0, 10, 10))"},
+		{filter: "T_BEFORE(datetime, DATE('2018-02-12T00:00:00Z'))"},
+		{filter: "UPPER(Name) = 'X'"},
+		{filter: "Quantity = 1", lang: "cql2"},
+		{filter: `{"op":"=","args":[{"property":"Quantity"}]}`, lang: "cql2-json"},
+		{filter: `{"op":"and","args":[{"property":"Quantity"},1]}`, lang: "cql2-json"},
+		{filter: `{"op":"in","args":[{"property":"Quantity"},1]}`, lang: "cql2-json"},
+		{filter: `{"op":"like","args":[{"property":"Name"},1]}`, lang: "cql2-json"},
+		{filter: `{"op":"=",`, lang: "cql2-json"},
+	}
+	for _, test := range invalid {
+		if _, err := parseCQLFilter(test.filter, test.lang, "", ds); err == nil {
+			t.Errorf("%s: expected an error", test.filter)
+		}
+	}
+	if _, err := parseCQLFilter("Quantity = 1", "", "EPSG:1234", ds); err == nil {
+		t.Errorf("an unknown filter-crs should be an error")
+	}
+}
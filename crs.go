@@ -0,0 +1,475 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	crs84URI      = "http://www.opengis.net/def/crs/OGC/1.3/CRS84"
+	epsgURIPrefix = "http://www.opengis.net/def/crs/EPSG/0/"
+)
+
+// CoordinateReferenceSystem converts positions between a supported CRS and WGS84 longitude,
+// latitude. Projected positions are easting, northing; the optional third value is kept as is.
+type CoordinateReferenceSystem struct {
+	Code string
+	URI  string
+
+	// latLon is set for geographic CRSs whose official axis order is latitude first. It is only
+	// used where the axis order is defined by the CRS, such as the crs and bbox-crs parameters.
+	latLon     bool
+	projection projection
+	datum      *helmert
+}
+
+type projection interface {
+	// forward projects longitude and latitude in radians
+	forward(lon, lat float64) (x, y float64)
+	// inverse returns longitude and latitude in radians
+	inverse(x, y float64) (lon, lat float64)
+}
+
+type ellipsoid struct {
+	a float64
+	f float64
+}
+
+var (
+	wgs84Ellipsoid    = ellipsoid{a: 6378137, f: 1 / 298.257223563}
+	grs80Ellipsoid    = ellipsoid{a: 6378137, f: 1 / 298.257222101}
+	airy1830Ellipsoid = ellipsoid{a: 6377563.396, f: 1 - 6356256.909/6377563.396}
+)
+
+func (el ellipsoid) e() float64 {
+	return math.Sqrt(el.f * (2 - el.f))
+}
+
+// lookupCRS finds a supported CRS from an EPSG code, as EPSG:n, an OGC CRS URI or URN, or a
+// bare number, or from CRS84.
+func lookupCRS(name string) (*CoordinateReferenceSystem, error) {
+	name = strings.TrimSpace(name)
+	name = strings.Trim(name, "[]")
+	upper := strings.ToUpper(name)
+
+	if upper == "CRS84" || upper == "OGC:CRS84" || name == crs84URI || upper == "URN:OGC:DEF:CRS:OGC:1.3:CRS84" {
+		return &CoordinateReferenceSystem{Code: "CRS84", URI: crs84URI}, nil
+	}
+
+	codeText := name
+	switch {
+	case strings.HasPrefix(upper, "EPSG:"):
+		codeText = name[len("EPSG:"):]
+	case strings.HasPrefix(name, epsgURIPrefix):
+		codeText = name[len(epsgURIPrefix):]
+	case strings.HasPrefix(upper, "URN:OGC:DEF:CRS:EPSG:"):
+		codeText = name[strings.LastIndex(name, ":")+1:]
+	}
+	code, err := strconv.Atoi(codeText)
+	if err != nil {
+		return nil, errors.New("unknown crs " + name)
+	}
+	return lookupEPSG(code)
+}
+
+func lookupEPSG(code int) (*CoordinateReferenceSystem, error) {
+	c := &CoordinateReferenceSystem{Code: "EPSG:" + strconv.Itoa(code), URI: epsgURIPrefix + strconv.Itoa(code)}
+
+	switch {
+	case code == 4326 || code == 4258:
+		// WGS84 and ETRS89 geographic, latitude first
+		c.latLon = true
+	case code == 3857 || code == 900913:
+		c.projection = &webMercator{}
+	case code == 3395:
+		c.projection = newMercator(wgs84Ellipsoid)
+	case code >= 32601 && code <= 32660:
+		c.projection = newUTM(wgs84Ellipsoid, code-32600, false)
+	case code >= 32701 && code <= 32760:
+		c.projection = newUTM(wgs84Ellipsoid, code-32700, true)
+	case code >= 25828 && code <= 25838:
+		// ETRS89 / UTM, ETRS89 is treated as equal to WGS84
+		c.projection = newUTM(grs80Ellipsoid, code-25800, false)
+	case code == 3006:
+		// SWEREF99 TM
+		c.projection = newTransverseMercator(grs80Ellipsoid, 0, 15, 0.9996, 500000, 0)
+	case code >= 5105 && code <= 5130:
+		// ETRS89 / NTM zones 5 to 30, the Norwegian national grid
+		zone := code - 5100
+		c.projection = newTransverseMercator(grs80Ellipsoid, 58, float64(zone)+0.5, 1, 100000, 1000000)
+	case code == 27700:
+		// OSGB36 / British National Grid
+		c.projection = newTransverseMercator(airy1830Ellipsoid, 49, -2, 0.9996012717, 400000, -100000)
+		c.datum = osgb36Datum
+	case code == 2154:
+		// RGF93 / Lambert-93
+		c.projection = newLambertConformalConic(grs80Ellipsoid, 46.5, 3, 49, 44, 700000, 6600000)
+	default:
+		return nil, fmt.Errorf("unsupported crs EPSG:%d", code)
+	}
+	return c, nil
+}
+
+// isWGS84 is true when positions in the CRS are WGS84 longitude, latitude apart from the axis order
+func (c *CoordinateReferenceSystem) isWGS84() bool {
+	return c.projection == nil && c.datum == nil
+}
+
+// toWGS84 converts a position in the CRS into WGS84 longitude, latitude
+func (c *CoordinateReferenceSystem) toWGS84(p []float64) []float64 {
+	if len(p) < 2 || c.isWGS84() {
+		return p
+	}
+	lon, lat := p[0]*math.Pi/180, p[1]*math.Pi/180
+	if c.projection != nil {
+		lon, lat = c.projection.inverse(p[0], p[1])
+	}
+	if c.datum != nil {
+		lon, lat = c.datum.toWGS84(lon, lat)
+	}
+	p[0], p[1] = lon*180/math.Pi, lat*180/math.Pi
+	return p
+}
+
+// fromWGS84 converts WGS84 longitude, latitude into a position in the CRS
+func (c *CoordinateReferenceSystem) fromWGS84(p []float64) []float64 {
+	if len(p) < 2 || c.isWGS84() {
+		return p
+	}
+	lon, lat := p[0]*math.Pi/180, p[1]*math.Pi/180
+	if c.datum != nil {
+		lon, lat = c.datum.fromWGS84(lon, lat)
+	}
+	if c.projection != nil {
+		p[0], p[1] = c.projection.forward(lon, lat)
+	} else {
+		p[0], p[1] = lon*180/math.Pi, lat*180/math.Pi
+	}
+	return p
+}
+
+// fromWGS84InAxisOrder converts WGS84 longitude, latitude into a position in the CRS, with
+// latitude first for CRSs that define that axis order
+func (c *CoordinateReferenceSystem) fromWGS84InAxisOrder(p []float64) []float64 {
+	p = c.fromWGS84(p)
+	if c.latLon {
+		p = swapAxes(p)
+	}
+	return p
+}
+
+// toWGS84FromAxisOrder is the inverse of fromWGS84InAxisOrder
+func (c *CoordinateReferenceSystem) toWGS84FromAxisOrder(p []float64) []float64 {
+	if c.latLon {
+		p = swapAxes(p)
+	}
+	return c.toWGS84(p)
+}
+
+// projectFeature converts the geometry and bbox of a WGS84 feature into the CRS. It fails when
+// a position lies where the projection has no finite coordinates, such as 90 degrees from the
+// central meridian of a transverse Mercator projection, leaving the feature partly converted.
+func (c *CoordinateReferenceSystem) projectFeature(f *Feature) error {
+	if f.Geometry != nil {
+		f.Geometry.eachPosition(c.fromWGS84InAxisOrder)
+		if !f.Geometry.isFinite() {
+			return fmt.Errorf("feature %s lies outside the area of %s", f.Id, c.Code)
+		}
+	}
+	if f.BoundingBox != nil {
+		f.BoundingBox = transformBoundingBox(f.BoundingBox, c.fromWGS84InAxisOrder)
+		if !allFinite(f.BoundingBox) {
+			return fmt.Errorf("the bbox of feature %s lies outside the area of %s", f.Id, c.Code)
+		}
+	}
+	return nil
+}
+
+// transformBoundingBox converts a 2D or 3D bbox with fn by converting the corners and edge
+// midpoints of its horizontal extent and taking the extent of the result. The vertical
+// extent is kept as is.
+func transformBoundingBox(bbox []float64, fn func(p []float64) []float64) []float64 {
+	if len(bbox) != 4 && len(bbox) != 6 {
+		return bbox
+	}
+	dims := len(bbox) / 2
+	minx, miny, maxx, maxy := bbox[0], bbox[1], bbox[dims], bbox[dims+1]
+	midx, midy := (minx+maxx)/2, (miny+maxy)/2
+	var result []float64
+	for _, corner := range [][]float64{
+		{minx, miny}, {maxx, miny}, {maxx, maxy}, {minx, maxy},
+		{midx, miny}, {maxx, midy}, {midx, maxy}, {minx, midy},
+	} {
+		p := fn(corner)
+		if result == nil {
+			result = []float64{p[0], p[1], p[0], p[1]}
+			continue
+		}
+		result[0] = math.Min(result[0], p[0])
+		result[1] = math.Min(result[1], p[1])
+		result[2] = math.Max(result[2], p[0])
+		result[3] = math.Max(result[3], p[1])
+	}
+	if dims == 3 {
+		result = []float64{result[0], result[1], bbox[2], result[2], result[3], bbox[5]}
+	}
+	return result
+}
+
+// -------------  Web Mercator ------------- //
+
+type webMercator struct{}
+
+func (m *webMercator) forward(lon, lat float64) (float64, float64) {
+	r := wgs84Ellipsoid.a
+	// clamp to the limits of the projection
+	lat = math.Max(math.Min(lat, 85.06*math.Pi/180), -85.06*math.Pi/180)
+	return r * lon, r * math.Log(math.Tan(math.Pi/4+lat/2))
+}
+
+func (m *webMercator) inverse(x, y float64) (float64, float64) {
+	r := wgs84Ellipsoid.a
+	return x / r, math.Pi/2 - 2*math.Atan(math.Exp(-y/r))
+}
+
+// -------------  Mercator ------------- //
+
+type mercator struct {
+	a float64
+	e float64
+}
+
+func newMercator(el ellipsoid) *mercator {
+	return &mercator{a: el.a, e: el.e()}
+}
+
+func (m *mercator) forward(lon, lat float64) (float64, float64) {
+	// clamp to the limits of the projection, as the poles are infinitely far away
+	lat = math.Max(math.Min(lat, 85.06*math.Pi/180), -85.06*math.Pi/180)
+	es := m.e * math.Sin(lat)
+	return m.a * lon, m.a * math.Log(math.Tan(math.Pi/4+lat/2)*math.Pow((1-es)/(1+es), m.e/2))
+}
+
+func (m *mercator) inverse(x, y float64) (float64, float64) {
+	return x / m.a, latitudeFromIsometric(math.Exp(-y/m.a), m.e)
+}
+
+// latitudeFromIsometric solves lat = pi/2 - 2 atan(t ((1 - e sin lat) / (1 + e sin lat))^(e/2))
+func latitudeFromIsometric(t float64, e float64) float64 {
+	lat := math.Pi/2 - 2*math.Atan(t)
+	for i := 0; i < 15; i++ {
+		es := e * math.Sin(lat)
+		next := math.Pi/2 - 2*math.Atan(t*math.Pow((1-es)/(1+es), e/2))
+		if math.Abs(next-lat) < 1e-12 {
+			return next
+		}
+		lat = next
+	}
+	return lat
+}
+
+// -------------  Transverse Mercator ------------- //
+
+// transverseMercator uses the Krüger series to third order in n, which is accurate to well
+// below a millimetre within a few degrees of the central meridian.
+type transverseMercator struct {
+	e              float64
+	k0             float64
+	lon0           float64
+	falseEasting   float64
+	falseNorthing  float64
+	radius         float64
+	alpha          [3]float64
+	beta           [3]float64
+	delta          [3]float64
+	originNorthing float64
+}
+
+func newUTM(el ellipsoid, zone int, south bool) *transverseMercator {
+	falseNorthing := 0.0
+	if south {
+		falseNorthing = 10000000
+	}
+	return newTransverseMercator(el, 0, float64(zone)*6-183, 0.9996, 500000, falseNorthing)
+}
+
+// newTransverseMercator takes the latitude of origin and central meridian in degrees
+func newTransverseMercator(el ellipsoid, lat0, lon0, k0, falseEasting, falseNorthing float64) *transverseMercator {
+	n := el.f / (2 - el.f)
+	n2, n3 := n*n, n*n*n
+	tm := &transverseMercator{
+		e:             el.e(),
+		k0:            k0,
+		lon0:          lon0 * math.Pi / 180,
+		falseEasting:  falseEasting,
+		falseNorthing: falseNorthing,
+		radius:        el.a / (1 + n) * (1 + n2/4 + n2*n2/64),
+		alpha:         [3]float64{n/2 - 2*n2/3 + 5*n3/16, 13*n2/48 - 3*n3/5, 61 * n3 / 240},
+		beta:          [3]float64{n/2 - 2*n2/3 + 37*n3/96, n2/48 + n3/15, 17 * n3 / 480},
+		delta:         [3]float64{2*n - 2*n2/3 - 2*n3, 7*n2/3 - 8*n3/5, 56 * n3 / 15},
+	}
+	xi, _ := tm.gaussKruger(tm.lon0, lat0*math.Pi/180)
+	tm.originNorthing = k0 * tm.radius * xi
+	return tm
+}
+
+func (tm *transverseMercator) gaussKruger(lon, lat float64) (float64, float64) {
+	t := math.Sinh(math.Atanh(math.Sin(lat)) - tm.e*math.Atanh(tm.e*math.Sin(lat)))
+	dlon := lon - tm.lon0
+	xiPrime := math.Atan2(t, math.Cos(dlon))
+	etaPrime := math.Atanh(math.Sin(dlon) / math.Sqrt(1+t*t))
+	xi, eta := xiPrime, etaPrime
+	for j := 1; j <= 3; j++ {
+		a := tm.alpha[j-1]
+		xi += a * math.Sin(2*float64(j)*xiPrime) * math.Cosh(2*float64(j)*etaPrime)
+		eta += a * math.Cos(2*float64(j)*xiPrime) * math.Sinh(2*float64(j)*etaPrime)
+	}
+	return xi, eta
+}
+
+func (tm *transverseMercator) forward(lon, lat float64) (float64, float64) {
+	if math.Cos(lon-tm.lon0) <= 0 {
+		// the projection only covers the hemisphere around its central meridian
+		return math.NaN(), math.NaN()
+	}
+	xi, eta := tm.gaussKruger(lon, lat)
+	x := tm.falseEasting + tm.k0*tm.radius*eta
+	y := tm.falseNorthing + tm.k0*tm.radius*xi - tm.originNorthing
+	return x, y
+}
+
+func (tm *transverseMercator) inverse(x, y float64) (float64, float64) {
+	xi := (y - tm.falseNorthing + tm.originNorthing) / (tm.k0 * tm.radius)
+	eta := (x - tm.falseEasting) / (tm.k0 * tm.radius)
+	xiPrime, etaPrime := xi, eta
+	for j := 1; j <= 3; j++ {
+		b := tm.beta[j-1]
+		xiPrime -= b * math.Sin(2*float64(j)*xi) * math.Cosh(2*float64(j)*eta)
+		etaPrime -= b * math.Cos(2*float64(j)*xi) * math.Sinh(2*float64(j)*eta)
+	}
+	chi := math.Asin(math.Sin(xiPrime) / math.Cosh(etaPrime))
+	lat := chi
+	for j := 1; j <= 3; j++ {
+		lat += tm.delta[j-1] * math.Sin(2*float64(j)*chi)
+	}
+	lon := tm.lon0 + math.Atan2(math.Sinh(etaPrime), math.Cos(xiPrime))
+	return lon, lat
+}
+
+// -------------  Lambert Conformal Conic ------------- //
+
+// lambertConformalConic is the two standard parallel variant, EPSG method 9802
+type lambertConformalConic struct {
+	a             float64
+	e             float64
+	n             float64
+	f             float64
+	rho0          float64
+	lon0          float64
+	falseEasting  float64
+	falseNorthing float64
+}
+
+// newLambertConformalConic takes the latitude of origin, central meridian and standard
+// parallels in degrees
+func newLambertConformalConic(el ellipsoid, lat0, lon0, lat1, lat2, falseEasting, falseNorthing float64) *lambertConformalConic {
+	toRad := math.Pi / 180
+	l := &lambertConformalConic{a: el.a, e: el.e(), lon0: lon0 * toRad, falseEasting: falseEasting, falseNorthing: falseNorthing}
+	m1, m2 := l.m(lat1*toRad), l.m(lat2*toRad)
+	t0, t1, t2 := l.t(lat0*toRad), l.t(lat1*toRad), l.t(lat2*toRad)
+	l.n = (math.Log(m1) - math.Log(m2)) / (math.Log(t1) - math.Log(t2))
+	l.f = m1 / (l.n * math.Pow(t1, l.n))
+	l.rho0 = l.a * l.f * math.Pow(t0, l.n)
+	return l
+}
+
+func (l *lambertConformalConic) m(lat float64) float64 {
+	es := l.e * math.Sin(lat)
+	return math.Cos(lat) / math.Sqrt(1-es*es)
+}
+
+func (l *lambertConformalConic) t(lat float64) float64 {
+	es := l.e * math.Sin(lat)
+	return math.Tan(math.Pi/4-lat/2) / math.Pow((1-es)/(1+es), l.e/2)
+}
+
+func (l *lambertConformalConic) forward(lon, lat float64) (float64, float64) {
+	rho := l.a * l.f * math.Pow(l.t(lat), l.n)
+	theta := l.n * (lon - l.lon0)
+	return l.falseEasting + rho*math.Sin(theta), l.falseNorthing + l.rho0 - rho*math.Cos(theta)
+}
+
+func (l *lambertConformalConic) inverse(x, y float64) (float64, float64) {
+	dx := x - l.falseEasting
+	dy := l.rho0 - (y - l.falseNorthing)
+	rho := math.Copysign(math.Hypot(dx, dy), l.n)
+	theta := math.Atan2(dx, dy)
+	if l.n < 0 {
+		theta = math.Atan2(-dx, -dy)
+	}
+	t := math.Pow(rho/(l.a*l.f), 1/l.n)
+	return theta/l.n + l.lon0, latitudeFromIsometric(t, l.e)
+}
+
+// -------------  Datum shift ------------- //
+
+// helmert is a seven parameter position vector transformation from WGS84 to a local datum
+type helmert struct {
+	ellipsoid  ellipsoid
+	tx, ty, tz float64
+	// rotations in arc seconds and scale in parts per million
+	rx, ry, rz float64
+	s          float64
+}
+
+// osgb36Datum is the transformation published by Ordnance Survey, accurate to a few metres
+var osgb36Datum = &helmert{
+	ellipsoid: airy1830Ellipsoid,
+	tx:        -446.448, ty: 125.157, tz: -542.060,
+	rx: -0.1502, ry: -0.2470, rz: -0.8421,
+	s: 20.4894,
+}
+
+func (h *helmert) fromWGS84(lon, lat float64) (float64, float64) {
+	x, y, z := toGeocentric(wgs84Ellipsoid, lon, lat)
+	x, y, z = h.apply(x, y, z, 1)
+	return fromGeocentric(h.ellipsoid, x, y, z)
+}
+
+func (h *helmert) toWGS84(lon, lat float64) (float64, float64) {
+	x, y, z := toGeocentric(h.ellipsoid, lon, lat)
+	x, y, z = h.apply(x, y, z, -1)
+	return fromGeocentric(wgs84Ellipsoid, x, y, z)
+}
+
+// apply runs the transformation forwards with sign 1, or approximately backwards with sign -1
+func (h *helmert) apply(x, y, z float64, sign float64) (float64, float64, float64) {
+	secondsToRad := math.Pi / (180 * 3600)
+	rx, ry, rz := sign*h.rx*secondsToRad, sign*h.ry*secondsToRad, sign*h.rz*secondsToRad
+	s := 1 + sign*h.s*1e-6
+	return sign*h.tx + s*x - rz*y + ry*z,
+		sign*h.ty + rz*x + s*y - rx*z,
+		sign*h.tz - ry*x + rx*y + s*z
+}
+
+func toGeocentric(el ellipsoid, lon, lat float64) (float64, float64, float64) {
+	e2 := el.f * (2 - el.f)
+	sinLat := math.Sin(lat)
+	nu := el.a / math.Sqrt(1-e2*sinLat*sinLat)
+	return nu * math.Cos(lat) * math.Cos(lon), nu * math.Cos(lat) * math.Sin(lon), nu * (1 - e2) * sinLat
+}
+
+func fromGeocentric(el ellipsoid, x, y, z float64) (float64, float64) {
+	e2 := el.f * (2 - el.f)
+	p := math.Hypot(x, y)
+	lat := math.Atan2(z, p*(1-e2))
+	for i := 0; i < 10; i++ {
+		sinLat := math.Sin(lat)
+		nu := el.a / math.Sqrt(1-e2*sinLat*sinLat)
+		lat = math.Atan2(z+e2*nu*sinLat, p)
+	}
+	return math.Atan2(y, x), lat
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+const degrees = math.Pi / 180
+
+func TestLookupCRS(t *testing.T) {
+	tests := []struct {
+		name   string
+		code   string
+		latLon bool
+		err    bool
+	}{
+		{name: "CRS84", code: "CRS84"},
+		{name: "OGC:CRS84", code: "CRS84"},
+		{name: "http://www.opengis.net/def/crs/OGC/1.3/CRS84", code: "CRS84"},
+		{name: "urn:ogc:def:crs:OGC:1.3:CRS84", code: "CRS84"},
+		{name: "EPSG:4326", code: "EPSG:4326", latLon: true},
+		{name: "epsg:25833", code: "EPSG:25833"},
+		{name: "http://www.opengis.net/def/crs/EPSG/0/3857", code: "EPSG:3857"},
+		{name: "urn:ogc:def:crs:EPSG::32632", code: "EPSG:32632"},
+		{name: "[EPSG:27700]", code: "EPSG:27700"},
+		{name: "3006", code: "EPSG:3006"},
+		{name: "EPSG:5110", code: "EPSG:5110"},
+		{name: "EPSG:1234", err: true},
+		{name: "EPSG:x", err: true},
+		{name: "WGS84", err: true},
+	}
+	for _, test := range tests {
+		crs, err := lookupCRS(test.name)
+		if test.err {
+			if err == nil {
+				t.Errorf("%s: expected an error, got %s", test.name, crs.Code)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if crs.Code != test.code || crs.latLon != test.latLon {
+			t.Errorf("%s: got %s, latLon %v", test.name, crs.Code, crs.latLon)
+		}
+	}
+}
+
+// TestFromWGS84 checks projected positions against the worked examples of the EPSG guidance note
+// 7-2 and Ordnance Survey, closed form values and meridian arcs found by numerical integration
+func TestFromWGS84(t *testing.T) {
+	tests := []struct {
+		name      string
+		code      string
+		lon, lat  float64
+		x, y      float64
+		tolerance float64
+	}{
+		{name: "web mercator", code: "EPSG:3857", lon: 10, lat: 60, x: 1113194.908, y: 8399737.890, tolerance: 0.001},
+		{name: "world mercator", code: "EPSG:3395", lon: 10, lat: 60, x: 1113194.908, y: 8362698.549, tolerance: 0.001},
+		{name: "UTM origin", code: "EPSG:32631", lon: 3, lat: 0, x: 500000, y: 0, tolerance: 0.001},
+		{name: "UTM central meridian", code: "EPSG:32632", lon: 9, lat: 60, x: 500000, y: 6651411.190, tolerance: 0.001},
+		{name: "UTM south", code: "EPSG:32733", lon: 15, lat: -30, x: 500000, y: 6681214.647, tolerance: 0.001},
+		{name: "ETRS89 UTM", code: "EPSG:25833", lon: 15, lat: 60, x: 500000, y: 6651411.190, tolerance: 0.001},
+		{name: "SWEREF99 TM", code: "EPSG:3006", lon: 15, lat: 60, x: 500000, y: 6651411.190, tolerance: 0.001},
+		{name: "NTM origin", code: "EPSG:5110", lon: 10.5, lat: 58, x: 100000, y: 1000000, tolerance: 0.001},
+		{name: "NTM central meridian", code: "EPSG:5110", lon: 10.5, lat: 60, x: 100000, y: 1222790.147, tolerance: 0.001},
+		{name: "Lambert-93 origin", code: "EPSG:2154", lon: 3, lat: 46.5, x: 700000, y: 6600000, tolerance: 0.001},
+		// Caister water tower in ETRS89, the Helmert transformation is accurate to a few metres
+		{name: "British National Grid", code: "EPSG:27700", lon: 1.716073972, lat: 52.658007833, x: 651409.903, y: 313177.270, tolerance: 5},
+	}
+	for _, test := range tests {
+		crs, err := lookupCRS(test.code)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		p := crs.fromWGS84([]float64{test.lon, test.lat, 12})
+		if math.Abs(p[0]-test.x) > test.tolerance || math.Abs(p[1]-test.y) > test.tolerance {
+			t.Errorf("%s: got %.3f, %.3f, expected %.3f, %.3f", test.name, p[0], p[1], test.x, test.y)
+		}
+		if p[2] != 12 {
+			t.Errorf("%s: the third value changed to %f", test.name, p[2])
+		}
+	}
+}
+
+func TestProjections(t *testing.T) {
+	// the US survey foot
+	foot := 1200.0 / 3937
+	clarke1866 := ellipsoid{a: 6378206.4, f: 1 / 294.9786982}
+	tests := []struct {
+		name       string
+		projection projection
+		lon, lat   float64
+		x, y       float64
+		tolerance  float64
+	}{
+		{
+			name:       "EPSG guidance note transverse mercator",
+			projection: newTransverseMercator(airy1830Ellipsoid, 49, -2, 0.9996012717, 400000, -100000),
+			lon:        0.5, lat: 50.5,
+			x: 577274.99, y: 69740.49, tolerance: 0.01,
+		},
+		{
+			name:       "Ordnance Survey transverse mercator",
+			projection: newTransverseMercator(airy1830Ellipsoid, 49, -2, 0.9996012717, 400000, -100000),
+			lon:        1 + 43.0/60 + 4.5177/3600, lat: 52 + 39.0/60 + 27.2531/3600,
+			x: 651409.903, y: 313177.270, tolerance: 0.001,
+		},
+		{
+			name: "EPSG guidance note lambert conformal conic",
+			projection: newLambertConformalConic(clarke1866, 27+50.0/60, -99, 28+23.0/60, 30+17.0/60,
+				2000000*foot, 0),
+			lon: -96, lat: 28.5,
+			x: 2963503.91 * foot, y: 254759.80 * foot, tolerance: 0.01 * foot,
+		},
+	}
+	for _, test := range tests {
+		x, y := test.projection.forward(test.lon*degrees, test.lat*degrees)
+		if math.Abs(x-test.x) > test.tolerance || math.Abs(y-test.y) > test.tolerance {
+			t.Errorf("%s: got %.3f, %.3f, expected %.3f, %.3f", test.name, x, y, test.x, test.y)
+		}
+		lon, lat := test.projection.inverse(test.x, test.y)
+		if math.Abs(lon/degrees-test.lon) > 1e-7 || math.Abs(lat/degrees-test.lat) > 1e-7 {
+			t.Errorf("%s: inverse gives %.9f, %.9f", test.name, lon/degrees, lat/degrees)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	tests := []struct {
+		code     string
+		lon, lat float64
+	}{
+		{"EPSG:3857", -73.9857, 40.7484},
+		{"EPSG:3395", 151.2153, -33.8568},
+		{"EPSG:32632", 10.7522, 59.9139},
+		{"EPSG:32633", 18.9553, 69.6492},
+		{"EPSG:32733", 18.4241, -33.9249},
+		{"EPSG:25833", 5.3221, 60.3913},
+		{"EPSG:3006", 18.0686, 59.3293},
+		{"EPSG:5110", 10.7522, 59.9139},
+		{"EPSG:5105", 5.3221, 60.3913},
+		{"EPSG:27700", -0.1276, 51.5072},
+		{"EPSG:27700", -3.1883, 55.9533},
+		{"EPSG:2154", 2.3522, 48.8566},
+		{"EPSG:2154", 7.2620, 43.7102},
+	}
+	for _, test := range tests {
+		crs, err := lookupCRS(test.code)
+		if err != nil {
+			t.Fatalf("%s: %v", test.code, err)
+		}
+		p := crs.toWGS84(crs.fromWGS84([]float64{test.lon, test.lat}))
+		// 1e-8 degrees is about a millimetre, the datum shift of the British National Grid is
+		// reversed to about a centimetre
+		tolerance := 1e-8
+		if crs.datum != nil {
+			tolerance = 1e-7
+		}
+		if math.Abs(p[0]-test.lon) > tolerance || math.Abs(p[1]-test.lat) > tolerance {
+			t.Errorf("%s: %f, %f comes back as %.10f, %.10f", test.code, test.lon, test.lat, p[0], p[1])
+		}
+	}
+}
+
+func TestAxisOrder(t *testing.T) {
+	crs, _ := lookupCRS("EPSG:4326")
+	p := crs.fromWGS84InAxisOrder([]float64{10, 60})
+	if p[0] != 60 || p[1] != 10 {
+		t.Errorf("EPSG:4326 positions should be latitude first, got %v", p)
+	}
+	p = crs.toWGS84FromAxisOrder(p)
+	if p[0] != 10 || p[1] != 60 {
+		t.Errorf("EPSG:4326 positions should come back longitude first, got %v", p)
+	}
+}
+
+func TestTransformBoundingBox(t *testing.T) {
+	crs, _ := lookupCRS("EPSG:3857")
+	bbox := transformBoundingBox([]float64{-10, -20, 5, 10, 20, 50}, crs.fromWGS84)
+	if len(bbox) != 6 || bbox[2] != 5 || bbox[5] != 50 {
+		t.Fatalf("the vertical extent should be kept, got %v", bbox)
+	}
+	back := transformBoundingBox(bbox, crs.toWGS84)
+	expected := []float64{-10, -20, 5, 10, 20, 50}
+	for i := range expected {
+		if math.Abs(back[i]-expected[i]) > 1e-9 {
+			t.Errorf("bbox comes back as %v", back)
+			break
+		}
+	}
+}
+
+func TestFeatureBoundingBoxCrs(t *testing.T) {
+	sourceCrs, _ := lookupCRS("EPSG:25833")
+	ds := &Dataset{Name: "test", Type: "features", sourceCrs: sourceCrs}
+	// the geometry is in web mercator by its SRID, and so is its bbox
+	e := &Entity{
+		ID:         "http://data.example.org/1",
+		References: map[string]any{},
+		Properties: map[string]any{
+			flatgeoWktPredicate:  "SRID=3857;POINT (1113194.908 8399737.890)",
+			flatgeoBboxPredicate: []any{1113194.908, 8399737.890, 1113194.908, 8399737.890},
+		},
+	}
+	ec := NewEntityCollection()
+	ec.Entities = append(ec.Entities, e)
+	f := makeFeature(e, ds, newFeatureBatch(ec, ds, ""))
+	if len(f.BoundingBox) != 4 || math.Abs(f.BoundingBox[0]-10) > 1e-6 || math.Abs(f.BoundingBox[1]-60) > 1e-6 {
+		t.Errorf("the bbox should be transformed from the CRS of the geometry, got %v", f.BoundingBox)
+	}
+}
+
+func TestProjectFeatures(t *testing.T) {
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	hub := &fakeDatahub{datasets: map[string][]string{
+		"outside": {
+			`{"http://data.mimiro.io/models/flatgeo/wkt":"POINT (15 60)"}`,
+			`{"http://data.mimiro.io/models/flatgeo/wkt":"POINT (105 0)"}`,
+			`{"http://data.mimiro.io/models/flatgeo/wkt":"POINT (15 -90)"}`,
+			`{"http://data.mimiro.io/models/flatgeo/wkt":"POINT (-170 10)"}`,
+		},
+	}}
+	serveDatahub(t, hub)
+	ds := &Dataset{Name: "outside", Type: "features", RemoteDataset: "outside"}
+	RemoteDatahub.Datasets = []*Dataset{ds}
+
+	tests := []struct {
+		crs string
+		ids []string
+	}{
+		// 90 degrees from the central meridian of UTM zone 33 is infinitely far east, and further
+		// away is on the other side of the earth
+		{crs: "EPSG:32633", ids: []string{"http://data.example.org/0", "http://data.example.org/2"}},
+		// the poles are clamped to the limits of Mercator
+		{crs: "EPSG:3395", ids: []string{"http://data.example.org/0", "http://data.example.org/1", "http://data.example.org/2", "http://data.example.org/3"}},
+		{crs: "EPSG:3857", ids: []string{"http://data.example.org/0", "http://data.example.org/1", "http://data.example.org/2", "http://data.example.org/3"}},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/datasets/outside/changes?crs="+test.crs, nil)
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(req, rec)
+		c.SetParamNames("dataset")
+		c.SetParamValues("outside")
+		if err := getChanges(c); err != nil || rec.Code != http.StatusOK {
+			t.Errorf("%s: got %d, %v", test.crs, rec.Code, err)
+			continue
+		}
+		var items []map[string]any
+		if err := json.Unmarshal(rec.Body.Bytes(), &items); err != nil {
+			t.Fatalf("%s: %v", test.crs, err)
+		}
+		ids := make([]string, 0)
+		for _, item := range items {
+			if item["type"] == "Feature" {
+				ids = append(ids, item["id"].(string))
+			}
+		}
+		if strings.Join(ids, " ") != strings.Join(test.ids, " ") {
+			t.Errorf("%s: got features %v, expected %v", test.crs, ids, test.ids)
+		}
+	}
+	if !strings.Contains(logged.String(), "outside the area of EPSG:32633") {
+		t.Errorf("the features left out should be logged, got %q", logged.String())
+	}
+
+	// a collection keeps the members inside the area
+	utm, _ := lookupCRS("EPSG:32633")
+	fc := &FeatureCollection{Id: "c", BoundingBox: []float64{15, 0, 105, 60}, Features: []*Feature{
+		wktFeature(t, "POINT (15 60)"),
+		wktFeature(t, "POINT (105 0)"),
+	}}
+	projectFeatures([]any{fc}, utm, ds)
+	if len(fc.Features) != 1 || fc.BoundingBox != nil {
+		t.Errorf("got %d members and bbox %v", len(fc.Features), fc.BoundingBox)
+	}
+	if _, err := json.Marshal(fc); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestReprojectOutsideArea(t *testing.T) {
+	sourceCrs, _ := lookupCRS("EPSG:32633")
+	ds := &Dataset{Name: "test", sourceCrs: sourceCrs}
+	e := testEntity("1", map[string]any{flatgeoWktPredicate: "POINT (1e20 0)"})
+	if g, err := makeGeomentryFromEntity(e, ds, e); err == nil {
+		data, _ := json.Marshal(g)
+		t.Errorf("expected an error, got %s", data)
+	}
+}
@@ -0,0 +1,656 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// featureFilter decides whether a feature is part of a response. Filters see the features in
+// WGS84, before they are projected into the crs of the response.
+type featureFilter func(f *Feature) bool
+
+// matchesAll is true when the feature passes all the filters
+func matchesAll(f *Feature, filters []featureFilter) bool {
+	for _, filter := range filters {
+		if !filter(f) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseBoundingBoxFilter reads a bbox parameter, minx,miny,maxx,maxy or
+// minx,miny,minz,maxx,maxy,maxz as in OGC API - Features, in the crs named by bboxCrs or in
+// CRS84 when it is empty. It returns a filter matching the features whose geometry intersects
+// the box. A minx greater than maxx denotes a box crossing the antimeridian. Geometries
+// spanning more than 180 degrees of longitude are taken to cross the antimeridian too, see
+// unwrapAntimeridian.
+func parseBoundingBoxFilter(bboxParam string, bboxCrs string) (featureFilter, error) {
+	parts := strings.Split(bboxParam, ",")
+	if len(parts) != 4 && len(parts) != 6 {
+		return nil, errors.New("bbox must have 4 or 6 numbers")
+	}
+	bbox := make([]float64, len(parts))
+	for i, part := range parts {
+		v, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+			return nil, errors.New("bbox must have 4 or 6 numbers")
+		}
+		bbox[i] = v
+	}
+
+	if bboxCrs != "" {
+		crs, err := lookupCRS(bboxCrs)
+		if err != nil {
+			return nil, err
+		}
+		if crs.isWGS84() {
+			// keep the edges as they are, so that boxes crossing the antimeridian stay so
+			if crs.latLon {
+				bbox = swapBoundingBoxAxes(bbox)
+			}
+		} else {
+			bbox = transformBoundingBox(bbox, crs.toWGS84FromAxisOrder)
+		}
+	}
+	if !isValidBoundingBox(bbox) {
+		return nil, errors.New("bbox has a minimum above its maximum")
+	}
+
+	boxes := splitAtAntimeridian(bbox)
+	// unwrapped geometries reach east of the antimeridian, where the boxes are met 360 degrees on
+	unwrappedBoxes := append([][]float64{}, boxes...)
+	for _, box := range boxes {
+		dims := len(box) / 2
+		east := append([]float64{}, box...)
+		east[0] += 360
+		east[dims] += 360
+		unwrappedBoxes = append(unwrappedBoxes, east)
+	}
+	return func(f *Feature) bool {
+		if f.Geometry == nil {
+			return false
+		}
+		g, candidates := f.Geometry, boxes
+		if unwrapped, crosses := unwrapAntimeridian(f.Geometry); crosses {
+			g, candidates = unwrapped, unwrappedBoxes
+		}
+		for _, box := range candidates {
+			if intersectsBoundingBox(g, box) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+// unwrapAntimeridian returns a copy of a geometry spanning more than 180 degrees of longitude,
+// with its negative longitudes moved 360 degrees east, and true. Such a geometry is taken to
+// cross the antimeridian, as RFC 7946 geometries that do are rarely split, rather than to go
+// the long way around the globe. Geometries really spanning more than half the globe are
+// compared as if they crossed the antimeridian.
+func unwrapAntimeridian(g *Geometry) (*Geometry, bool) {
+	extent := computeBoundingBox(g)
+	if extent == nil || extent[len(extent)/2]-extent[0] <= 180 {
+		return g, false
+	}
+	unwrapped := g.copy()
+	unwrapped.eachPosition(func(p []float64) []float64 {
+		if len(p) >= 2 && p[0] < 0 {
+			p[0] += 360
+		}
+		return p
+	})
+	return unwrapped, true
+}
+
+// parseDatetimeFilter reads a datetime parameter as in OGC API - Features: an instant, such as
+// 2018-02-12T23:20:50Z or 2018-02-12, or an interval of two instants separated by a slash,
+// where .. or an empty string is an open end. A date covers the whole day. It returns a filter
+// matching the features whose time overlaps, or features without a time that were recorded in
+// the UDA endpoint within it.
+func parseDatetimeFilter(datetime string) (featureFilter, error) {
+	var start, end time.Time
+	var err error
+	if bounds := strings.Split(datetime, "/"); len(bounds) == 2 {
+		if isOpenBound(bounds[0]) && isOpenBound(bounds[1]) {
+			return nil, errors.New("datetime interval must have a start or an end")
+		}
+		if !isOpenBound(bounds[0]) {
+			if start, _, err = parseDatetimeBound(bounds[0]); err != nil {
+				return nil, err
+			}
+		}
+		if !isOpenBound(bounds[1]) {
+			if _, end, err = parseDatetimeBound(bounds[1]); err != nil {
+				return nil, err
+			}
+		}
+		if !start.IsZero() && !end.IsZero() && end.Before(start) {
+			return nil, errors.New("datetime interval ends before it starts")
+		}
+	} else if len(bounds) == 1 {
+		if start, end, err = parseDatetimeBound(datetime); err != nil {
+			return nil, err
+		}
+	} else {
+		return nil, errors.New("invalid datetime " + datetime)
+	}
+
+	return func(f *Feature) bool {
+		featureStart, featureEnd := f.start, f.end
+		if f.Time == nil {
+			if f.recorded.IsZero() {
+				return false
+			}
+			featureStart, featureEnd = f.recorded, f.recorded
+		}
+		// zero times are open ends
+		return (end.IsZero() || featureStart.IsZero() || !featureStart.After(end)) &&
+			(start.IsZero() || featureEnd.IsZero() || !featureEnd.Before(start))
+	}, nil
+}
+
+func isOpenBound(bound string) bool {
+	return bound == "" || bound == ".."
+}
+
+// parseDatetimeBound reads an RFC 3339 timestamp or a date and returns the first and last
+// instant it covers
+func parseDatetimeBound(value string) (time.Time, time.Time, error) {
+	t, err := parseTime(value, "")
+	if err != nil {
+		return t, t, errors.New("invalid datetime " + value)
+	}
+	if len(strings.TrimSpace(value)) == len("2006-01-02") {
+		return t, t.AddDate(0, 0, 1).Add(-time.Nanosecond), nil
+	}
+	return t, t, nil
+}
+
+// splitAtAntimeridian returns the bbox, or the two boxes either side of the antimeridian when
+// it crosses it
+func splitAtAntimeridian(bbox []float64) [][]float64 {
+	if !crossesAntimeridian(bbox) {
+		return [][]float64{bbox}
+	}
+	dims := len(bbox) / 2
+	west := append([]float64{}, bbox...)
+	east := append([]float64{}, bbox...)
+	west[dims] = 180
+	east[0] = -180
+	return [][]float64{west, east}
+}
+
+// intersectsBoundingBox is true when the geometry and the box share at least one point. With
+// a 3D box the geometry must also reach into its vertical range. Geometries without a third
+// coordinate are only compared horizontally.
+func intersectsBoundingBox(g *Geometry, bbox []float64) bool {
+	dims := len(bbox) / 2
+	box := [4]float64{bbox[0], bbox[1], bbox[dims], bbox[dims+1]}
+
+	if dims == 3 {
+		extent := computeBoundingBox(g)
+		if len(extent) == 6 && (extent[5] < bbox[2] || extent[2] > bbox[5]) {
+			return false
+		}
+	}
+
+	switch g.Type {
+	case "GeometryCollection":
+		for _, member := range g.Geometries {
+			if intersectsBoundingBox(member, bbox) {
+				return true
+			}
+		}
+		return false
+	case "Point":
+		return pointInBox(pointPosition(g), box)
+	case "MultiPoint":
+		for _, p := range g.Coordinates {
+			if position, ok := p.([]float64); ok && pointInBox(position, box) {
+				return true
+			}
+		}
+		return false
+	case "LineString":
+		return lineIntersectsBox(linePositions(g), box)
+	case "MultiLineString", "Polygon":
+		// the parts of a MultiLineString and the rings of a Polygon are both lists of positions
+		lines := make([][][]float64, 0, len(g.Coordinates))
+		for _, line := range g.Coordinates {
+			if positions, ok := line.([][]float64); ok {
+				lines = append(lines, positions)
+			}
+		}
+		if g.Type == "Polygon" {
+			return polygonIntersectsBox(lines, box)
+		}
+		for _, line := range lines {
+			if lineIntersectsBox(line, box) {
+				return true
+			}
+		}
+		return false
+	case "MultiPolygon":
+		for _, polygon := range g.Coordinates {
+			if rings, ok := polygon.([][][]float64); ok && polygonIntersectsBox(rings, box) {
+				return true
+			}
+		}
+		return false
+	}
+	return false
+}
+
+func pointInBox(p []float64, box [4]float64) bool {
+	return len(p) >= 2 && p[0] >= box[0] && p[0] <= box[2] && p[1] >= box[1] && p[1] <= box[3]
+}
+
+// lineIntersectsBox is true when a vertex lies in the box or a segment crosses one of its edges
+func lineIntersectsBox(line [][]float64, box [4]float64) bool {
+	for _, p := range line {
+		if pointInBox(p, box) {
+			return true
+		}
+	}
+	corners := [][]float64{{box[0], box[1]}, {box[2], box[1]}, {box[2], box[3]}, {box[0], box[3]}}
+	for i := 1; i < len(line); i++ {
+		for j := range corners {
+			if segmentsIntersect(line[i-1], line[i], corners[j], corners[(j+1)%4]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// polygonIntersectsBox is true when the boundary of the polygon meets the box, or when the
+// box lies inside the polygon and not inside one of its holes
+func polygonIntersectsBox(rings [][][]float64, box [4]float64) bool {
+	if len(rings) == 0 {
+		return false
+	}
+	for _, ring := range rings {
+		if lineIntersectsBox(ring, box) {
+			return true
+		}
+	}
+	// the boundary is outside the box, so the box is either inside or outside the polygon
+	corner := []float64{box[0], box[1]}
+	if !pointInRing(corner, rings[0]) {
+		return false
+	}
+	for _, hole := range rings[1:] {
+		if pointInRing(corner, hole) {
+			return false
+		}
+	}
+	return true
+}
+
+// pointInRing tests the point against a closed ring by ray casting
+func pointInRing(p []float64, ring [][]float64) bool {
+	inside := false
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		a, b := ring[i], ring[j]
+		if (a[1] > p[1]) != (b[1] > p[1]) && p[0] < (b[0]-a[0])*(p[1]-a[1])/(b[1]-a[1])+a[0] {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// segmentsIntersect is true when the segments ab and cd share a point
+func segmentsIntersect(a, b, c, d []float64) bool {
+	d1 := orientation(c, d, a)
+	d2 := orientation(c, d, b)
+	d3 := orientation(a, b, c)
+	d4 := orientation(a, b, d)
+	if ((d1 > 0 && d2 < 0) || (d1 < 0 && d2 > 0)) && ((d3 > 0 && d4 < 0) || (d3 < 0 && d4 > 0)) {
+		return true
+	}
+	return (d1 == 0 && onSegment(c, d, a)) || (d2 == 0 && onSegment(c, d, b)) ||
+		(d3 == 0 && onSegment(a, b, c)) || (d4 == 0 && onSegment(a, b, d))
+}
+
+func orientation(a, b, c []float64) float64 {
+	return (b[0]-a[0])*(c[1]-a[1]) - (b[1]-a[1])*(c[0]-a[0])
+}
+
+// onSegment is true when p, known to be on the line through a and b, lies between them
+func onSegment(a, b, p []float64) bool {
+	return p[0] >= math.Min(a[0], b[0]) && p[0] <= math.Max(a[0], b[0]) &&
+		p[1] >= math.Min(a[1], b[1]) && p[1] <= math.Max(a[1], b[1])
+}
+
+// pointPosition is the position of a Point, whose coordinates are the numbers of the position
+func pointPosition(g *Geometry) []float64 {
+	position := make([]float64, 0, len(g.Coordinates))
+	for _, c := range g.Coordinates {
+		if f, ok := c.(float64); ok {
+			position = append(position, f)
+		}
+	}
+	return position
+}
+
+// linePositions are the positions of a LineString
+func linePositions(g *Geometry) [][]float64 {
+	positions := make([][]float64, 0, len(g.Coordinates))
+	for _, c := range g.Coordinates {
+		if p, ok := c.([]float64); ok {
+			positions = append(positions, p)
+		}
+	}
+	return positions
+}
+
+// the query parameters of the endpoints other than property filters, in the order of the API
+// definition
+var (
+	itemsParameterNames = []string{"limit", "offset", "cursor", "bbox", "bbox-crs", "datetime",
+		"filter", "filter-lang", "filter-crs", "f"}
+	changesParameterNames = []string{"since", "limit", "crs", "bbox", "bbox-crs", "datetime",
+		"filter", "filter-lang", "filter-crs", "f"}
+
+	itemsParameters   = parameterSet(itemsParameterNames)
+	changesParameters = parameterSet(changesParameterNames)
+)
+
+func parameterSet(names []string) map[string]bool {
+	set := make(map[string]bool, len(names))
+	for _, name := range names {
+		set[name] = true
+	}
+	return set
+}
+
+// parseFeatureFilters reads the filters of a request from its query parameters. All parameters
+// other than the parameters of the endpoint are property filters.
+func parseFeatureFilters(c echo.Context, ds *Dataset, parameters map[string]bool) ([]featureFilter, error) {
+	filters := make([]featureFilter, 0)
+	if c.QueryParam("bbox") != "" {
+		filter, err := parseBoundingBoxFilter(c.QueryParam("bbox"), c.QueryParam("bbox-crs"))
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, filter)
+	}
+	if c.QueryParam("datetime") != "" {
+		filter, err := parseDatetimeFilter(c.QueryParam("datetime"))
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, filter)
+	}
+	if c.QueryParam("filter") != "" {
+		filter, err := parseCQLFilter(c.QueryParam("filter"), c.QueryParam("filter-lang"), c.QueryParam("filter-crs"), ds)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, filter)
+	}
+
+	names := make([]string, 0)
+	for name := range c.QueryParams() {
+		if !parameters[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		// repeated parameters must all match, as in Quantity=>5&Quantity=<10
+		for _, value := range c.QueryParams()[name] {
+			filter, err := parsePropertyFilter(name, value, ds)
+			if err != nil {
+				return nil, err
+			}
+			filters = append(filters, filter)
+		}
+	}
+	return filters, nil
+}
+
+// filterChanges keeps the changes that match the filters. Deletions always pass, as a client
+// must be told about them, and feature collections pass when one of their features does.
+func filterChanges(items []any, filters []featureFilter) []any {
+	if len(filters) == 0 {
+		return items
+	}
+	kept := make([]any, 0, len(items))
+	for _, item := range items {
+		switch v := item.(type) {
+		case *Feature:
+			if v.IsDeleted || matchesAll(v, filters) {
+				kept = append(kept, v)
+			}
+		case *FeatureCollection:
+			matches := v.IsDeleted
+			for _, f := range v.Features {
+				matches = matches || matchesAll(f, filters)
+			}
+			if matches {
+				kept = append(kept, v)
+			}
+		default:
+			// the context and continuation
+			kept = append(kept, item)
+		}
+	}
+	return kept
+}
+
+// the comparison operators of property filters
+const (
+	operatorEqual          = "="
+	operatorNotEqual       = "!="
+	operatorGreater        = ">"
+	operatorGreaterOrEqual = ">="
+	operatorLess           = "<"
+	operatorLessOrEqual    = "<="
+)
+
+// parsePropertyFilter reads a property filter from a query parameter. The operator is given in
+// the value, as in Quantity=>=10, or, as browsers send the comparison typed in the address bar,
+// at the end of the name, as in Quantity>=10 (name Quantity>, value 10), or in the name itself
+// without a value, as in Quantity>10. The id parameter compares the id of the feature, as id does
+// in CQL2 filters.
+func parsePropertyFilter(name string, value string, ds *Dataset) (featureFilter, error) {
+	operator := operatorEqual
+	if value == "" {
+		if i := strings.IndexAny(name, "<>!="); i > 0 {
+			name, value = name[:i], name[i:]
+		}
+	} else if strings.HasSuffix(name, ">") || strings.HasSuffix(name, "<") || strings.HasSuffix(name, "!") {
+		value = name[len(name)-1:] + "=" + value
+		name = name[:len(name)-1]
+	}
+	for _, op := range []string{operatorGreaterOrEqual, operatorLessOrEqual, operatorNotEqual, operatorGreater, operatorLess, operatorEqual} {
+		if strings.HasPrefix(value, op) {
+			operator, value = op, value[len(op):]
+			break
+		}
+	}
+
+	if name == "id" {
+		return func(f *Feature) bool {
+			return compareValues(f.Id, operator, value)
+		}, nil
+	}
+
+	m, queryable := ds.lookupQueryable(name)
+	if !queryable {
+		return nil, fmt.Errorf("unknown parameter %s, the queryable properties are %s", name, strings.Join(ds.knownQueryables(), ", "))
+	}
+	propertyType := ""
+	if m != nil {
+		propertyType = m.Type
+	}
+
+	var wanted any = value
+	switch propertyType {
+	case "int", "float":
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be compared with a number", name)
+		}
+		wanted = number
+	case "bool":
+		b, err := toBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be compared with true or false", name)
+		}
+		if operator != operatorEqual && operator != operatorNotEqual {
+			return nil, fmt.Errorf("%s can only be compared for equality", name)
+		}
+		wanted = b
+	case "date":
+		t, err := parseTime(value, "")
+		if err != nil {
+			return nil, fmt.Errorf("%s must be compared with a date or an RFC 3339 time", name)
+		}
+		wanted = t
+	}
+
+	return func(f *Feature) bool {
+		v, found := featurePropertyValue(f, name, m)
+		if !found {
+			return false
+		}
+		// a list matches when one of its values does, or for != when none is equal
+		var values []any
+		switch list := v.(type) {
+		case []any:
+			values = list
+		case []string:
+			for _, item := range list {
+				values = append(values, item)
+			}
+		default:
+			return compareValues(v, operator, wanted)
+		}
+		if operator == operatorNotEqual {
+			for _, item := range values {
+				if compareValues(item, operatorEqual, wanted) {
+					return false
+				}
+			}
+			return true
+		}
+		for _, item := range values {
+			if compareValues(item, operator, wanted) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+// featurePropertyValue finds a property of the feature by its key, by the name the mapping gives
+// it, or by its local name
+func featurePropertyValue(f *Feature, name string, m *PropertyMap) (any, bool) {
+	if v, found := f.Properties[name]; found {
+		return v, true
+	}
+	if m != nil && m.Name != "" {
+		if v, found := f.Properties[m.Name]; found {
+			return v, true
+		}
+	}
+	keys := make([]string, 0, len(f.Properties))
+	for k := range f.Properties {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if stripUrl(k) == name || strings.HasSuffix(k, ":"+name) {
+			return f.Properties[k], true
+		}
+	}
+	return nil, false
+}
+
+// compareValues compares a property value with the value of a filter. Numbers, booleans and
+// times are compared as such when the filter value has that type, which it has for mapped
+// properties. Otherwise numbers, and strings holding numbers, are compared numerically when the
+// filter value is a number, and everything else as strings.
+func compareValues(v any, operator string, wanted any) bool {
+	var c int
+	switch w := wanted.(type) {
+	case float64:
+		n, err := toNumber(v)
+		if err != nil {
+			return false
+		}
+		c = compareFloats(n, w)
+	case bool:
+		b, err := toBool(v)
+		if err != nil {
+			return false
+		}
+		c = 1
+		if b == w {
+			c = 0
+		}
+	case time.Time:
+		t, err := parseTime(v, "")
+		if err != nil {
+			return false
+		}
+		c = compareFloats(float64(t.UnixNano()), float64(w.UnixNano()))
+	case string:
+		switch n := v.(type) {
+		case float64, int64:
+			number, err := strconv.ParseFloat(w, 64)
+			if err != nil {
+				return false
+			}
+			f, _ := toNumber(n)
+			c = compareFloats(f, number)
+		case string:
+			a, errA := strconv.ParseFloat(strings.TrimSpace(n), 64)
+			b, errB := strconv.ParseFloat(w, 64)
+			if errA == nil && errB == nil {
+				c = compareFloats(a, b)
+			} else {
+				c = strings.Compare(n, w)
+			}
+		case bool:
+			c = strings.Compare(strconv.FormatBool(n), w)
+		default:
+			// nested objects can not be compared
+			return false
+		}
+	}
+
+	switch operator {
+	case operatorNotEqual:
+		return c != 0
+	case operatorGreater:
+		return c > 0
+	case operatorGreaterOrEqual:
+		return c >= 0
+	case operatorLess:
+		return c < 0
+	case operatorLessOrEqual:
+		return c <= 0
+	}
+	return c == 0
+}
+
+func compareFloats(a float64, b float64) int {
+	if a < b {
+		return -1
+	} else if a > b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// wktFeature is a feature with the geometry of the WKT text
+func wktFeature(t *testing.T, wkt string) *Feature {
+	g, err := parseWKT(wkt)
+	if err != nil {
+		t.Fatalf("%s: %v", wkt, err)
+	}
+	return &Feature{Id: wkt, Type: "Feature", Geometry: g, Properties: map[string]interface{}{}}
+}
+
+func TestBoundingBoxFilter(t *testing.T) {
+	tests := []struct {
+		bbox    string
+		bboxCrs string
+		wkt     string
+		matches bool
+	}{
+		{bbox: "0,0,10,10", wkt: "POINT (5 5)", matches: true},
+		{bbox: "0,0,10,10", wkt: "POINT (10 10)", matches: true},
+		{bbox: "0,0,10,10", wkt: "POINT (11 5)"},
+		// lines and polygons passing through the box without a position inside it
+		{bbox: "0,0,10,10", wkt: "LINESTRING (-5 5, 15 5)", matches: true},
+		{bbox: "0,0,10,10", wkt: "LINESTRING (-5 -5, -5 15)"},
+		{bbox: "0,0,10,10", wkt: "POLYGON ((-5 -5, 15 -5, 15 15, -5 15, -5 -5))", matches: true},
+		{bbox: "4,4,6,6", wkt: "POLYGON ((0 0, 10 0, 10 10, 0 10, 0 0), (3 3, 7 3, 7 7, 3 7, 3 3))"},
+		{bbox: "0,0,10,10", wkt: "GEOMETRYCOLLECTION (POINT (20 20), POINT (5 5))", matches: true},
+		// heights
+		{bbox: "0,0,0,10,10,100", wkt: "POINT Z (5 5 50)", matches: true},
+		{bbox: "0,0,0,10,10,100", wkt: "POINT Z (5 5 150)"},
+		{bbox: "0,0,0,10,10,100", wkt: "POINT (5 5)", matches: true},
+		// boxes crossing the antimeridian
+		{bbox: "170,-10,-170,10", wkt: "POINT (175 0)", matches: true},
+		{bbox: "170,-10,-170,10", wkt: "POINT (-175 0)", matches: true},
+		{bbox: "170,-10,-170,10", wkt: "POINT (0 0)"},
+		// geometries crossing the antimeridian
+		{bbox: "-10,-10,10,10", wkt: "LINESTRING (170 0, -170 0)"},
+		{bbox: "175,-10,178,10", wkt: "LINESTRING (170 0, -170 0)", matches: true},
+		{bbox: "-178,-10,-175,10", wkt: "LINESTRING (170 0, -170 0)", matches: true},
+		{bbox: "179,-10,-179,10", wkt: "LINESTRING (170 0, -170 0)", matches: true},
+		{bbox: "-10,-10,10,10", wkt: "POLYGON ((170 -5, -170 -5, -170 5, 170 5, 170 -5))"},
+		{bbox: "-179,-1,-178,1", wkt: "POLYGON ((170 -5, -170 -5, -170 5, 170 5, 170 -5))", matches: true},
+		// another crs
+		{bbox: "60,0,70,10", bboxCrs: "EPSG:4326", wkt: "POINT (5 65)", matches: true},
+		{bbox: "500000,6600000,600000,6700000", bboxCrs: "EPSG:32633", wkt: "POINT (15.5 60)", matches: true},
+		{bbox: "500000,6600000,600000,6700000", bboxCrs: "EPSG:32633", wkt: "POINT (10 60)"},
+	}
+	for _, test := range tests {
+		filter, err := parseBoundingBoxFilter(test.bbox, test.bboxCrs)
+		if err != nil {
+			t.Errorf("%s: %v", test.bbox, err)
+			continue
+		}
+		if matches := filter(wktFeature(t, test.wkt)); matches != test.matches {
+			t.Errorf("bbox %s %s: %s matches %v", test.bbox, test.bboxCrs, test.wkt, matches)
+		}
+	}
+
+	for _, bbox := range []string{"", "1,2,3", "1,2,3,x", "0,10,10,0", "0,0,NaN,1", "1,2,3,4,5"} {
+		if _, err := parseBoundingBoxFilter(bbox, ""); err == nil {
+			t.Errorf("%q: expected an error", bbox)
+		}
+	}
+	if _, err := parseBoundingBoxFilter("0,0,1,1", "EPSG:1234"); err == nil {
+		t.Errorf("an unknown bbox-crs should be an error")
+	}
+}
+
+// timedFeature is a feature with the time range from start to end, where an empty bound is open
+func timedFeature(t *testing.T, start string, end string) *Feature {
+	f := &Feature{Time: &FeatureTime{}}
+	var err error
+	if start != "" {
+		if f.start, err = time.Parse(time.RFC3339, start); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if end != "" {
+		if f.end, err = time.Parse(time.RFC3339, end); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return f
+}
+
+func TestDatetimeFilter(t *testing.T) {
+	day := timedFeature(t, "2018-02-12T00:00:00Z", "2018-02-12T23:59:59Z")
+	instant := timedFeature(t, "2018-02-12T23:20:50Z", "2018-02-12T23:20:50Z")
+	openEnd := timedFeature(t, "2020-01-01T00:00:00Z", "")
+	recorded := &Feature{recorded: time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)}
+	timeless := &Feature{}
+
+	tests := []struct {
+		datetime string
+		feature  *Feature
+		matches  bool
+	}{
+		{datetime: "2018-02-12T23:20:50Z", feature: instant, matches: true},
+		{datetime: "2018-02-12T23:20:51Z", feature: instant},
+		{datetime: "2018-02-12", feature: instant, matches: true},
+		{datetime: "2018-02-13", feature: instant},
+		{datetime: "2018-02-12T12:00:00Z", feature: day, matches: true},
+		{datetime: "2018-01-01/2018-02-12", feature: instant, matches: true},
+		{datetime: "2018-01-01/2018-02-11", feature: instant},
+		{datetime: "../2018-02-12T23:20:50Z", feature: instant, matches: true},
+		{datetime: "2018-02-12T23:20:51Z/..", feature: instant},
+		{datetime: "/2018-03-01", feature: day, matches: true},
+		{datetime: "2030-01-01/..", feature: openEnd, matches: true},
+		{datetime: "../2019-12-31", feature: openEnd},
+		// features without a time match by the time they were recorded
+		{datetime: "2019-06-01", feature: recorded, matches: true},
+		{datetime: "2019-06-02/..", feature: recorded},
+		{datetime: "2019-06-01", feature: timeless},
+	}
+	for _, test := range tests {
+		filter, err := parseDatetimeFilter(test.datetime)
+		if err != nil {
+			t.Errorf("%s: %v", test.datetime, err)
+			continue
+		}
+		if matches := filter(test.feature); matches != test.matches {
+			t.Errorf("%s: matches %v", test.datetime, matches)
+		}
+	}
+
+	for _, datetime := range []string{"yesterday", "2018-13-01", "../..", "/", "2018-02-12/2018-02-11", "2018/2019/2020"} {
+		if _, err := parseDatetimeFilter(datetime); err == nil {
+			t.Errorf("%q: expected an error", datetime)
+		}
+	}
+}
+
+func TestPropertyFilter(t *testing.T) {
+	mapped := &Dataset{Name: "mapped", PropertyMapping: &PropertyMapping{
+		Include: []string{"Species"},
+		Mappings: []*PropertyMap{
+			{Property: "Quantity", Type: "int"},
+			{Property: "Date", Type: "date"},
+			{Property: "Active", Name: "active", Type: "bool"},
+		},
+	}}
+	unmapped := &Dataset{Name: "unmapped"}
+	unmapped.seenProperties.Store("http://data.example.org/Quantity", true)
+	unmapped.seenProperties.Store("http://data.example.org/Species", true)
+
+	mappedFeature := &Feature{Id: "http://data.example.org/1", Properties: map[string]interface{}{
+		"Quantity": int64(100),
+		"Date":     "2018-02-12",
+		"active":   true,
+		"Species":  []any{"Rhopilema nomadica", "Aurelia aurita"},
+	}}
+	unmappedFeature := &Feature{Id: "http://data.example.org/2", Properties: map[string]interface{}{
+		"http://data.example.org/Quantity": "100",
+		"http://data.example.org/Species":  "Rhopilema nomadica",
+	}}
+
+	tests := []struct {
+		ds      *Dataset
+		name    string
+		value   string
+		matches bool
+	}{
+		{ds: mapped, name: "Quantity", value: "100", matches: true},
+		{ds: mapped, name: "Quantity", value: ">10", matches: true},
+		{ds: mapped, name: "Quantity", value: ">=100", matches: true},
+		{ds: mapped, name: "Quantity", value: "<100"},
+		{ds: mapped, name: "Quantity>", value: "10", matches: true},
+		{ds: mapped, name: "Quantity<=99"},
+		{ds: mapped, name: "Quantity!", value: "100"},
+		{ds: mapped, name: "Date", value: ">2018-02-11", matches: true},
+		{ds: mapped, name: "Date", value: "<2018-02-12T00:00:00Z"},
+		{ds: mapped, name: "active", value: "true", matches: true},
+		{ds: mapped, name: "Active", value: "no"},
+		{ds: mapped, name: "Species", value: "Aurelia aurita", matches: true},
+		{ds: mapped, name: "Species", value: "!=Aurelia aurita"},
+		{ds: mapped, name: "Species", value: "!=Cyanea capillata", matches: true},
+		// strings holding numbers are compared as numbers
+		{ds: unmapped, name: "Quantity", value: ">5", matches: true},
+		{ds: unmapped, name: "Quantity", value: ">10", matches: true},
+		{ds: unmapped, name: "Quantity", value: "100.0", matches: true},
+		{ds: unmapped, name: "Quantity", value: "<20"},
+		{ds: unmapped, name: "Species", value: "Rhopilema nomadica", matches: true},
+		{ds: unmapped, name: "Species", value: ">Aurelia", matches: true},
+		// id is the id of the feature
+		{ds: mapped, name: "id", value: "http://data.example.org/1", matches: true},
+		{ds: mapped, name: "id", value: "!=http://data.example.org/1"},
+		{ds: unmapped, name: "id", value: "http://data.example.org/1"},
+	}
+	for _, test := range tests {
+		filter, err := parsePropertyFilter(test.name, test.value, test.ds)
+		if err != nil {
+			t.Errorf("%s %s=%s: %v", test.ds.Name, test.name, test.value, err)
+			continue
+		}
+		f := mappedFeature
+		if test.ds == unmapped {
+			f = unmappedFeature
+		}
+		if matches := filter(f); matches != test.matches {
+			t.Errorf("%s %s=%s: matches %v", test.ds.Name, test.name, test.value, matches)
+		}
+	}
+
+	invalid := []struct {
+		ds    *Dataset
+		name  string
+		value string
+	}{
+		{ds: mapped, name: "Quantity", value: ">ten"},
+		{ds: mapped, name: "Active", value: "maybe"},
+		{ds: mapped, name: "Active", value: ">true"},
+		{ds: mapped, name: "Date", value: "yesterday"},
+		{ds: mapped, name: "Colour", value: "red"},
+		{ds: unmapped, name: "Colour", value: "red"},
+	}
+	serveDatahub(t, &fakeDatahub{status: http.StatusServiceUnavailable})
+	for _, test := range invalid {
+		if _, err := parsePropertyFilter(test.name, test.value, test.ds); err == nil {
+			t.Errorf("%s %s=%s: expected an error", test.ds.Name, test.name, test.value)
+		}
+	}
+}
+
+func TestFeatureFilterParameters(t *testing.T) {
+	ds := &Dataset{Name: "mapped", PropertyMapping: &PropertyMapping{Include: []string{"Species"}}}
+	tests := []struct {
+		query   string
+		filters int
+		err     bool
+	}{
+		{query: "f=json&limit=10&offset=5", filters: 0},
+		{query: "bbox=0,0,1,1&datetime=2018-02-12&Species=x", filters: 3},
+		{query: "filter=Species%3D%27x%27&filter-lang=cql2-text", filters: 1},
+		{query: "id=http://data.example.org/1", filters: 1},
+		{query: "limt=10", err: true},
+	}
+	for _, test := range tests {
+		c := echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/collections/mapped/items?"+test.query, nil), httptest.NewRecorder())
+		filters, err := parseFeatureFilters(c, ds, itemsParameters)
+		if test.err != (err != nil) || len(filters) != test.filters {
+			t.Errorf("%s: got %d filters, %v", test.query, len(filters), err)
+		}
+	}
+}
@@ -0,0 +1,715 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+)
+
+const (
+	flatgeoCoordinatesPredicate = "http://data.mimiro.io/models/flatgeo/coordinates"
+	flatgeoGeometriesPredicate  = "http://data.mimiro.io/models/flatgeo/geometries"
+	flatgeoWktPredicate         = "http://data.mimiro.io/models/flatgeo/wkt"
+	flatgeoWkbPredicate         = "http://data.mimiro.io/models/flatgeo/wkb"
+	flatgeoDimensionPredicate   = "http://data.mimiro.io/models/flatgeo/dimension"
+)
+
+// flatgeoGeometryTypes maps the flatgeo geotype references to GeoJSON geometry types.
+//
+// The flatgeo/coordinates property is encoded per type as follows:
+//
+//	Point              [x, y]
+//	LineString         [x1, y1, x2, y2, ...] or [[x1, y1], [x2, y2], ...]
+//	MultiPoint         [x1, y1, x2, y2, ...] or [[x1, y1], [x2, y2], ...]
+//	Polygon            a single ring, flat or nested, or a list of rings where the first ring
+//	                   is the exterior and the rest are holes
+//	MultiLineString    a list of LineString encodings, or a single nested LineString
+//	MultiPolygon       a list of Polygon encodings
+//
+// Nested positions may have a third value. Flat lists hold 2 values per position, or 3 when
+// the flatgeo/dimension property is 3.
+//
+// A GeometryCollection has no coordinates but a flatgeo/geometries property holding
+// a list of nested entities, each with its own geotype and coordinates.
+var flatgeoGeometryTypes = map[string]string{
+	"http://data.mimiro.io/models/flatgeo/Point":              "Point",
+	"http://data.mimiro.io/models/flatgeo/LineString":         "LineString",
+	"http://data.mimiro.io/models/flatgeo/Polygon":            "Polygon",
+	"http://data.mimiro.io/models/flatgeo/MultiPoint":         "MultiPoint",
+	"http://data.mimiro.io/models/flatgeo/MultiLineString":    "MultiLineString",
+	"http://data.mimiro.io/models/flatgeo/MultiPolygon":       "MultiPolygon",
+	"http://data.mimiro.io/models/flatgeo/GeometryCollection": "GeometryCollection",
+}
+
+type Geometry struct {
+	Type        string        `json:"type"`
+	Coordinates []interface{} `json:"coordinates"`
+	Geometries  []*Geometry   `json:"geometries,omitempty"`
+
+	// srid is the spatial reference id carried by EWKT and EWKB input, 0 when unknown
+	srid int
+}
+
+// MarshalJSON writes geometries for collections and coordinates for all other types,
+// as GeoJSON does not allow both members on the same geometry object.
+func (g *Geometry) MarshalJSON() ([]byte, error) {
+	if g.Type == "GeometryCollection" {
+		geometries := g.Geometries
+		if geometries == nil {
+			geometries = make([]*Geometry, 0)
+		}
+		return json.Marshal(struct {
+			Type       string      `json:"type"`
+			Geometries []*Geometry `json:"geometries"`
+		}{g.Type, geometries})
+	}
+	coordinates := g.Coordinates
+	if coordinates == nil {
+		coordinates = make([]interface{}, 0)
+	}
+	return json.Marshal(struct {
+		Type        string        `json:"type"`
+		Coordinates []interface{} `json:"coordinates"`
+	}{g.Type, coordinates})
+}
+
+const (
+	axisOrderLonLat = "lonlat"
+	axisOrderLatLon = "latlon"
+)
+
+// makeGeomentryFromEntity builds the geometry of an entity. The sources are tried in order:
+// the flatgeo geotype and coordinates, a WKT string in flatgeo/wkt or in the WKT property
+// configured on the dataset, a hex (E)WKB string in flatgeo/wkb or in the WKB property
+// configured on the dataset, and last the latitude and longitude properties of the dataset.
+// They are read from the source entity, which is the entity itself unless its geometry is held
+// on a referenced entity. The third dimension is always taken from the entity itself.
+func makeGeomentryFromEntity(e *Entity, ds *Dataset, source *Entity) (*Geometry, error) {
+	if source == nil {
+		return nil, errors.New("no entity holding the geometry of entity " + e.ID)
+	}
+	g, err := makeGeometryFromSource(source, ds)
+	if err != nil {
+		return nil, err
+	}
+	if err := ds.reprojectToWGS84(g); err != nil {
+		return nil, err
+	}
+	if !g.isFinite() {
+		return nil, errors.New("the geometry lies outside the area of the crs of the dataset")
+	}
+	ds.applyThirdDimension(e, g)
+	ds.checkAxisOrder(g, e.ID)
+
+	return g, nil
+}
+
+// makeGeometryFromSource reads the geometry from the first of the geometry encodings found on
+// the entity
+func makeGeometryFromSource(e *Entity, ds *Dataset) (*Geometry, error) {
+	var g *Geometry
+	var err error
+	if _, hasGeotype := e.References[flatgeoGeotypePredicate]; hasGeotype {
+		g, err = makeFlatgeoGeometry(e)
+		if err != nil {
+			return nil, err
+		}
+		ds.applyAxisOrder(g)
+	} else if wkt, found := findGeometryString(e, flatgeoWktPredicate, ds.WktProperty); found {
+		g, err = parseWKT(wkt)
+		if err != nil {
+			return nil, err
+		}
+		ds.applyAxisOrder(g)
+	} else if wkb, found := findGeometryString(e, flatgeoWkbPredicate, ds.WkbProperty); found {
+		g, err = parseWKBHex(wkb)
+		if err != nil {
+			return nil, err
+		}
+		ds.applyAxisOrder(g)
+	} else if ds.LatitudeProperty != "" && ds.LongitudeProperty != "" {
+		g, err = makePointFromProperties(e, ds)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		return nil, errors.New("no geometry for entity " + e.ID)
+	}
+	return g, nil
+}
+
+// findGeometryString returns the string value of the flatgeo predicate, or of the property
+// configured on the dataset
+func findGeometryString(e *Entity, predicate string, property string) (string, bool) {
+	if value, err := e.getStringLiteralPropertyValue(predicate); err == nil {
+		return value, true
+	}
+	if property != "" {
+		if value, found := e.findPropertyValue(property); found {
+			if s, ok := value.(string); ok {
+				return s, true
+			}
+		}
+	}
+	return "", false
+}
+
+// makePointFromProperties builds a point from the latitude and longitude properties configured
+// on the dataset
+func makePointFromProperties(e *Entity, ds *Dataset) (*Geometry, error) {
+	lat, err := e.getNumberPropertyValue(ds.LatitudeProperty)
+	if err != nil {
+		return nil, fmt.Errorf("invalid latitude: %w", err)
+	}
+	lon, err := e.getNumberPropertyValue(ds.LongitudeProperty)
+	if err != nil {
+		return nil, fmt.Errorf("invalid longitude: %w", err)
+	}
+
+	g := &Geometry{}
+	g.Type = "Point"
+	g.Coordinates = []interface{}{lon, lat}
+	return g, nil
+}
+
+// applyThirdDimension gives all 2D positions the value of the elevation or depth property
+// configured on the dataset. Depth is positive downwards and becomes a negative elevation.
+// A dataset configured with 2 dimensions has any third values removed instead.
+func (ds *Dataset) applyThirdDimension(e *Entity, g *Geometry) {
+	if ds.Dimensions == 2 {
+		g.eachPosition(func(p []float64) []float64 {
+			if len(p) > 2 {
+				return p[:2]
+			}
+			return p
+		})
+		return
+	}
+
+	hasZ := false
+	z := 0.0
+	if ds.ElevationProperty != "" {
+		if elevation, err := e.getNumberPropertyValue(ds.ElevationProperty); err == nil {
+			hasZ, z = true, elevation
+		}
+	}
+	if !hasZ && ds.DepthProperty != "" {
+		if depth, err := e.getNumberPropertyValue(ds.DepthProperty); err == nil {
+			hasZ, z = true, -depth
+		}
+	}
+
+	if !hasZ {
+		return
+	}
+	g.eachPosition(func(p []float64) []float64 {
+		if len(p) == 2 {
+			p = append(p, z)
+		}
+		return p
+	})
+}
+
+// dimensionsSampleSize is the number of entities read to tell 3D datasets from flat ones
+const dimensionsSampleSize = 100
+
+// sampleDimensions reads the first entities of a dataset that configures neither its dimensions
+// nor a depth or elevation property, and takes it to be 3D when their geometries are. The
+// entities are read from the dataset holding the geometries, so that no referenced entities
+// have to be looked up. It is done once, in the background, so that the metadata of the dataset
+// does not change with the requests served. Until it is done the dataset is taken to be 2D.
+func (ds *Dataset) sampleDimensions() int {
+	ds.dimensionsSample.ensureInBackground(ds, "dimensions", func() error {
+		remoteDataset := ds.RemoteDataset
+		if ds.GeometryReference != "" && ds.GeometryDataset != "" {
+			remoteDataset = ds.GeometryDataset
+		}
+		ec, err := fetchChanges(remoteDataset, "", dimensionsSampleSize)
+		if err != nil {
+			return err
+		}
+		dimensions := 2
+		for _, e := range ec.Entities {
+			if e.IsDeleted || isFeatureCollectionEntity(e) {
+				continue
+			}
+			if g, err := makeGeometryFromSource(e, ds); err == nil && len(computeBoundingBox(g)) == 6 {
+				dimensions = 3
+				break
+			}
+		}
+		ds.dimensionsMu.Lock()
+		ds.sampledDimensions = dimensions
+		ds.dimensionsMu.Unlock()
+		return nil
+	})
+	ds.dimensionsMu.Lock()
+	defer ds.dimensionsMu.Unlock()
+	return ds.sampledDimensions
+}
+
+// makeFlatgeoGeometry builds the geometry from the flatgeo geotype and coordinates of the entity
+func makeFlatgeoGeometry(e *Entity) (*Geometry, error) {
+	geometryType, _ := e.getReferenceValue(flatgeoGeotypePredicate)
+	geojsonType, found := flatgeoGeometryTypes[geometryType]
+	if !found {
+		return nil, errors.New("unknown geometry type: " + geometryType)
+	}
+
+	if geojsonType == "GeometryCollection" {
+		return makeGeometryCollection(e)
+	}
+
+	coords, found := e.Properties[flatgeoCoordinatesPredicate]
+	if !found {
+		return nil, errors.New("no coordinates for geometry type: " + geojsonType)
+	}
+	dimensions := 2
+	if d, err := e.getIntLiteralPropertyValue(flatgeoDimensionPredicate); err == nil {
+		if d != 2 && d != 3 {
+			return nil, fmt.Errorf("flatgeo dimension must be 2 or 3, got %d", d)
+		}
+		dimensions = d
+	}
+	return makeGeometry(geojsonType, coords, dimensions)
+}
+
+func makeGeometryCollection(e *Entity) (*Geometry, error) {
+	g := &Geometry{}
+	g.Type = "GeometryCollection"
+	g.Geometries = make([]*Geometry, 0)
+
+	members, _ := e.Properties[flatgeoGeometriesPredicate].([]any)
+	for _, m := range members {
+		member, ok := m.(*Entity)
+		if !ok {
+			return nil, errors.New("geometry collection members must be entities")
+		}
+		mg, err := makeFlatgeoGeometry(member)
+		if err != nil {
+			return nil, fmt.Errorf("invalid geometry collection member: %w", err)
+		}
+		g.Geometries = append(g.Geometries, mg)
+	}
+	return g, nil
+}
+
+// makeGeometry builds a GeoJSON geometry of the given type from flatgeo coordinates. Flat
+// coordinate lists are split into positions of the given number of dimensions.
+func makeGeometry(geometryType string, coords any, dimensions int) (*Geometry, error) {
+	list, ok := coords.([]any)
+	if !ok {
+		return nil, fmt.Errorf("coordinates of %s must be a list", geometryType)
+	}
+
+	g := &Geometry{}
+	g.Type = geometryType
+	g.Coordinates = make([]interface{}, 0)
+
+	switch geometryType {
+	case "Point":
+		position, err := toPosition(list)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range position {
+			g.Coordinates = append(g.Coordinates, c)
+		}
+	case "LineString", "MultiPoint":
+		positions, err := toPositions(list, dimensions)
+		if err != nil {
+			return nil, err
+		}
+		if geometryType == "LineString" && len(positions) < 2 {
+			return nil, errors.New("a LineString needs at least two positions")
+		}
+		for _, p := range positions {
+			g.Coordinates = append(g.Coordinates, p)
+		}
+	case "Polygon":
+		rings, err := toPolygonRings(list, dimensions)
+		if err != nil {
+			return nil, err
+		}
+		for _, ring := range rings {
+			g.Coordinates = append(g.Coordinates, ring)
+		}
+	case "MultiLineString":
+		lines, err := toPositionLists(list, dimensions)
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range lines {
+			if len(line) < 2 {
+				return nil, errors.New("a LineString needs at least two positions")
+			}
+			g.Coordinates = append(g.Coordinates, line)
+		}
+	case "MultiPolygon":
+		for _, p := range list {
+			polygon, ok := p.([]any)
+			if !ok {
+				return nil, errors.New("each polygon of a MultiPolygon must be a list")
+			}
+			rings, err := toPolygonRings(polygon, dimensions)
+			if err != nil {
+				return nil, err
+			}
+			g.Coordinates = append(g.Coordinates, rings)
+		}
+	default:
+		return nil, errors.New("unknown geometry type: " + geometryType)
+	}
+
+	return g, nil
+}
+
+// toPosition converts a list of 2 or 3 numbers into a position
+func toPosition(list []any) ([]float64, error) {
+	if len(list) < 2 || len(list) > 3 {
+		return nil, fmt.Errorf("a position needs 2 or 3 numbers, got %d", len(list))
+	}
+	position := make([]float64, 0, len(list))
+	for _, v := range list {
+		f, ok := v.(float64)
+		if !ok {
+			return nil, fmt.Errorf("position contains non numeric value %v", v)
+		}
+		position = append(position, f)
+	}
+	return position, nil
+}
+
+// toPositions converts either a flat list of numbers or a list of positions into positions
+func toPositions(list []any, dimensions int) ([][]float64, error) {
+	positions := make([][]float64, 0)
+	if isNumberList(list) {
+		if len(list)%dimensions != 0 {
+			return nil, fmt.Errorf("flat coordinate list must have a multiple of %d values, got %d", dimensions, len(list))
+		}
+		for i := 0; i < len(list); i += dimensions {
+			position, err := toPosition(list[i : i+dimensions])
+			if err != nil {
+				return nil, err
+			}
+			positions = append(positions, position)
+		}
+		return positions, nil
+	}
+
+	for _, v := range list {
+		nested, ok := v.([]any)
+		if !ok {
+			return nil, errors.New("coordinate list mixes numbers and lists")
+		}
+		position, err := toPosition(nested)
+		if err != nil {
+			return nil, err
+		}
+		positions = append(positions, position)
+	}
+	return positions, nil
+}
+
+// toPositionLists converts a list of parts into lists of positions. A flat list of numbers,
+// or a single nested list of positions, is treated as one part.
+func toPositionLists(list []any, dimensions int) ([][][]float64, error) {
+	if isNumberList(list) || isPositionList(list) {
+		positions, err := toPositions(list, dimensions)
+		if err != nil {
+			return nil, err
+		}
+		return [][][]float64{positions}, nil
+	}
+
+	parts := make([][][]float64, 0)
+	for _, v := range list {
+		nested, ok := v.([]any)
+		if !ok {
+			return nil, errors.New("coordinate list mixes numbers and lists")
+		}
+		positions, err := toPositions(nested, dimensions)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, positions)
+	}
+	return parts, nil
+}
+
+// toPolygonRings converts a polygon encoding into its rings. The first ring is the exterior
+// ring and any following rings are holes. Rings are closed when the last position does not
+// repeat the first, and rewound to follow the right-hand rule of RFC 7946: exterior rings
+// counterclockwise and holes clockwise.
+func toPolygonRings(list []any, dimensions int) ([][][]float64, error) {
+	rings, err := toPositionLists(list, dimensions)
+	if err != nil {
+		return nil, err
+	}
+	if len(rings) == 0 {
+		return nil, errors.New("a polygon needs an exterior ring")
+	}
+	for i, ring := range rings {
+		ring = closeRing(ring)
+		if len(ring) < 4 {
+			return nil, fmt.Errorf("a polygon ring needs at least four positions, got %d", len(ring))
+		}
+		rings[i] = ring
+	}
+	windRings(rings)
+	return rings, nil
+}
+
+// windRings makes the exterior ring counterclockwise and all holes clockwise
+func windRings(rings [][][]float64) {
+	for i, ring := range rings {
+		exterior := i == 0
+		if (signedArea(ring) > 0) != exterior {
+			reverseRing(ring)
+		}
+	}
+}
+
+// rewind restores the winding order of all polygons in the geometry. It is needed after
+// transformations that mirror positions, such as swapping axes.
+func (g *Geometry) rewind() {
+	switch g.Type {
+	case "GeometryCollection":
+		for _, m := range g.Geometries {
+			m.rewind()
+		}
+	case "Polygon":
+		rings := make([][][]float64, 0, len(g.Coordinates))
+		for _, c := range g.Coordinates {
+			if ring, ok := c.([][]float64); ok {
+				rings = append(rings, ring)
+			}
+		}
+		windRings(rings)
+	case "MultiPolygon":
+		for _, c := range g.Coordinates {
+			if rings, ok := c.([][][]float64); ok {
+				windRings(rings)
+			}
+		}
+	}
+}
+
+func closeRing(ring [][]float64) [][]float64 {
+	if len(ring) == 0 {
+		return ring
+	}
+	first := ring[0]
+	last := ring[len(ring)-1]
+	if first[0] != last[0] || first[1] != last[1] {
+		closing := make([]float64, len(first))
+		copy(closing, first)
+		ring = append(ring, closing)
+	}
+	return ring
+}
+
+// signedArea is positive for counterclockwise rings and negative for clockwise rings
+func signedArea(ring [][]float64) float64 {
+	area := 0.0
+	for i := 0; i < len(ring)-1; i++ {
+		area += ring[i][0]*ring[i+1][1] - ring[i+1][0]*ring[i][1]
+	}
+	return area / 2
+}
+
+func reverseRing(ring [][]float64) {
+	for i, j := 0, len(ring)-1; i < j; i, j = i+1, j-1 {
+		ring[i], ring[j] = ring[j], ring[i]
+	}
+}
+
+func isNumberList(list []any) bool {
+	if len(list) == 0 {
+		return false
+	}
+	for _, v := range list {
+		if _, ok := v.(float64); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// isPositionList is true when every element is a list of 2 or 3 numbers. Flat parts always
+// hold at least two positions, so they are never mistaken for a single position.
+func isPositionList(list []any) bool {
+	if len(list) == 0 {
+		return false
+	}
+	for _, v := range list {
+		nested, ok := v.([]any)
+		if !ok || len(nested) < 2 || len(nested) > 3 || !isNumberList(nested) {
+			return false
+		}
+	}
+	return true
+}
+
+// eachPosition calls fn for every position of the geometry, including the members of a
+// geometry collection. The position returned by fn replaces the original position.
+func (g *Geometry) eachPosition(fn func(p []float64) []float64) {
+	if g.Type == "GeometryCollection" {
+		for _, m := range g.Geometries {
+			m.eachPosition(fn)
+		}
+		return
+	}
+
+	if g.Type == "Point" {
+		position := make([]float64, 0, len(g.Coordinates))
+		for _, c := range g.Coordinates {
+			if f, ok := c.(float64); ok {
+				position = append(position, f)
+			}
+		}
+		position = fn(position)
+		g.Coordinates = make([]interface{}, 0, len(position))
+		for _, c := range position {
+			g.Coordinates = append(g.Coordinates, c)
+		}
+		return
+	}
+
+	for k, c := range g.Coordinates {
+		switch v := c.(type) {
+		case []float64:
+			g.Coordinates[k] = fn(v)
+		case [][]float64:
+			for i := range v {
+				v[i] = fn(v[i])
+			}
+		case [][][]float64:
+			for i := range v {
+				for j := range v[i] {
+					v[i][j] = fn(v[i][j])
+				}
+			}
+		}
+	}
+}
+
+// isFinite is false when an ordinate of the geometry is NaN or infinite, which GeoJSON cannot hold
+func (g *Geometry) isFinite() bool {
+	finite := true
+	g.eachPosition(func(p []float64) []float64 {
+		finite = finite && allFinite(p)
+		return p
+	})
+	return finite
+}
+
+func allFinite(values []float64) bool {
+	for _, v := range values {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return false
+		}
+	}
+	return true
+}
+
+// copy returns a copy of the geometry that shares no positions with it
+func (g *Geometry) copy() *Geometry {
+	c := &Geometry{Type: g.Type, srid: g.srid}
+	for _, m := range g.Geometries {
+		c.Geometries = append(c.Geometries, m.copy())
+	}
+	if g.Coordinates != nil {
+		c.Coordinates = make([]interface{}, 0, len(g.Coordinates))
+	}
+	for _, coordinate := range g.Coordinates {
+		switch v := coordinate.(type) {
+		case []float64:
+			coordinate = append([]float64{}, v...)
+		case [][]float64:
+			line := make([][]float64, len(v))
+			for i, p := range v {
+				line[i] = append([]float64{}, p...)
+			}
+			coordinate = line
+		case [][][]float64:
+			rings := make([][][]float64, len(v))
+			for i, ring := range v {
+				rings[i] = make([][]float64, len(ring))
+				for j, p := range ring {
+					rings[i][j] = append([]float64{}, p...)
+				}
+			}
+			coordinate = rings
+		}
+		c.Coordinates = append(c.Coordinates, coordinate)
+	}
+	return c
+}
+
+// applyAxisOrder turns the positions of the geometry into lon, lat order
+func (ds *Dataset) applyAxisOrder(g *Geometry) {
+	if ds.AxisOrder == axisOrderLatLon {
+		g.eachPosition(swapAxes)
+		g.rewind()
+	}
+}
+
+// geometryCrs is the CRS the geometry is given in: the CRS of its SRID, or else the sourceCrs of
+// the dataset. It is nil when neither is known, for geometries in WGS84.
+func (ds *Dataset) geometryCrs(g *Geometry) (*CoordinateReferenceSystem, error) {
+	if g.srid != 0 {
+		return lookupEPSG(g.srid)
+	}
+	return ds.sourceCrs, nil
+}
+
+// reprojectToWGS84 converts the geometry from its CRS into WGS84 longitude, latitude
+func (ds *Dataset) reprojectToWGS84(g *Geometry) error {
+	source, err := ds.geometryCrs(g)
+	if err != nil {
+		return err
+	}
+	if source != nil && !source.isWGS84() {
+		g.eachPosition(source.toWGS84)
+	}
+	return nil
+}
+
+// swapAxes turns a [lat, lon] position into a [lon, lat] position, keeping any further values
+func swapAxes(p []float64) []float64 {
+	if len(p) >= 2 {
+		p[0], p[1] = p[1], p[0]
+	}
+	return p
+}
+
+func swapBoundingBoxAxes(bbox []float64) []float64 {
+	if len(bbox) != 4 && len(bbox) != 6 {
+		return bbox
+	}
+	dims := len(bbox) / 2
+	swapped := append([]float64{}, bbox...)
+	swapped[0], swapped[1] = bbox[1], bbox[0]
+	swapped[dims], swapped[dims+1] = bbox[dims+1], bbox[dims]
+	return swapped
+}
+
+// checkAxisOrder logs a warning, once per dataset, when a position of the geometry has a
+// latitude outside ±90 degrees, which usually means that the axis order of the dataset is wrong.
+func (ds *Dataset) checkAxisOrder(g *Geometry, id string) {
+	swapped := false
+	g.eachPosition(func(p []float64) []float64 {
+		if len(p) >= 2 && math.Abs(p[1]) > 90 {
+			swapped = true
+		}
+		return p
+	})
+	if swapped {
+		ds.axisOrderWarning.Do(func() {
+			log.Printf("dataset %s: entity %s has a latitude outside ±90, check the axisOrder setting of the dataset", ds.Name, id)
+		})
+	}
+}
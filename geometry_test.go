@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestWindRings(t *testing.T) {
+	tests := []struct {
+		name     string
+		rings    [][][]float64
+		expected [][][]float64
+	}{
+		{
+			name:     "counterclockwise exterior is kept",
+			rings:    [][][]float64{{{0, 0}, {1, 0}, {1, 1}, {0, 1}, {0, 0}}},
+			expected: [][][]float64{{{0, 0}, {1, 0}, {1, 1}, {0, 1}, {0, 0}}},
+		},
+		{
+			name:     "clockwise exterior is reversed",
+			rings:    [][][]float64{{{0, 0}, {0, 1}, {1, 1}, {1, 0}, {0, 0}}},
+			expected: [][][]float64{{{0, 0}, {1, 0}, {1, 1}, {0, 1}, {0, 0}}},
+		},
+		{
+			name: "counterclockwise hole is reversed",
+			rings: [][][]float64{
+				{{0, 0}, {10, 0}, {10, 10}, {0, 10}, {0, 0}},
+				{{2, 2}, {4, 2}, {4, 4}, {2, 2}},
+			},
+			expected: [][][]float64{
+				{{0, 0}, {10, 0}, {10, 10}, {0, 10}, {0, 0}},
+				{{2, 2}, {4, 4}, {4, 2}, {2, 2}},
+			},
+		},
+		{
+			name: "both wrong",
+			rings: [][][]float64{
+				{{0, 0, 5}, {0, 10, 5}, {10, 10, 5}, {10, 0, 5}, {0, 0, 5}},
+				{{2, 2, 5}, {4, 2, 5}, {4, 4, 5}, {2, 2, 5}},
+			},
+			expected: [][][]float64{
+				{{0, 0, 5}, {10, 0, 5}, {10, 10, 5}, {0, 10, 5}, {0, 0, 5}},
+				{{2, 2, 5}, {4, 4, 5}, {4, 2, 5}, {2, 2, 5}},
+			},
+		},
+	}
+	for _, test := range tests {
+		windRings(test.rings)
+		got, _ := json.Marshal(test.rings)
+		expected, _ := json.Marshal(test.expected)
+		if string(got) != string(expected) {
+			t.Errorf("%s: got %s, expected %s", test.name, got, expected)
+		}
+	}
+}
+
+func TestMakeGeometry(t *testing.T) {
+	tests := []struct {
+		name         string
+		geometryType string
+		coordinates  string
+		dimensions   int
+		geojson      string
+	}{
+		{
+			name:         "flat point",
+			geometryType: "Point",
+			coordinates:  `[10, 60]`,
+			geojson:      `{"type":"Point","coordinates":[10,60]}`,
+		},
+		{
+			name:         "flat linestring in 3D",
+			geometryType: "LineString",
+			coordinates:  `[1, 2, 3, 4, 5, 6]`,
+			dimensions:   3,
+			geojson:      `{"type":"LineString","coordinates":[[1,2,3],[4,5,6]]}`,
+		},
+		{
+			name:         "open flat ring is closed and rewound",
+			geometryType: "Polygon",
+			coordinates:  `[0, 0, 0, 1, 1, 1, 1, 0]`,
+			geojson:      `{"type":"Polygon","coordinates":[[[0,0],[1,0],[1,1],[0,1],[0,0]]]}`,
+		},
+		{
+			name:         "polygon with hole",
+			geometryType: "Polygon",
+			coordinates:  `[[[0, 0], [10, 0], [10, 10], [0, 10]], [[2, 2], [4, 2], [4, 4]]]`,
+			geojson:      `{"type":"Polygon","coordinates":[[[0,0],[10,0],[10,10],[0,10],[0,0]],[[2,2],[4,4],[4,2],[2,2]]]}`,
+		},
+		{
+			name:         "multipolygon",
+			geometryType: "MultiPolygon",
+			coordinates:  `[[[0, 0, 1, 0, 1, 1, 0, 0]], [[[5, 5], [5, 6], [6, 6], [5, 5]]]]`,
+			geojson:      `{"type":"MultiPolygon","coordinates":[[[[0,0],[1,0],[1,1],[0,0]]],[[[5,5],[6,6],[5,6],[5,5]]]]}`,
+		},
+		{
+			name:         "multilinestring of one nested line",
+			geometryType: "MultiLineString",
+			coordinates:  `[[0, 0], [1, 1]]`,
+			geojson:      `{"type":"MultiLineString","coordinates":[[[0,0],[1,1]]]}`,
+		},
+	}
+	for _, test := range tests {
+		var coordinates any
+		if err := json.Unmarshal([]byte(test.coordinates), &coordinates); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		dimensions := test.dimensions
+		if dimensions == 0 {
+			dimensions = 2
+		}
+		g, err := makeGeometry(test.geometryType, coordinates, dimensions)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		data, _ := json.Marshal(g)
+		if string(data) != test.geojson {
+			t.Errorf("%s: got %s, expected %s", test.name, data, test.geojson)
+		}
+	}
+}
+
+func TestMakeGeometryErrors(t *testing.T) {
+	tests := []struct {
+		geometryType string
+		coordinates  string
+	}{
+		{"Point", `[10]`},
+		{"Point", `[10, "60"]`},
+		{"LineString", `[1, 2]`},
+		{"LineString", `[1, 2, 3]`},
+		{"Polygon", `[]`},
+		{"Polygon", `[[]]`},
+		{"Polygon", `[0, 0, 1, 1]`},
+		{"MultiPolygon", `[[]]`},
+		{"MultiPolygon", `[[[]]]`},
+		{"MultiLineString", `[[0, 0]]`},
+		{"Circle", `[0, 0]`},
+	}
+	for _, test := range tests {
+		var coordinates any
+		if err := json.Unmarshal([]byte(test.coordinates), &coordinates); err != nil {
+			t.Fatalf("%s %s: %v", test.geometryType, test.coordinates, err)
+		}
+		if g, err := makeGeometry(test.geometryType, coordinates, 2); err == nil {
+			data, _ := json.Marshal(g)
+			t.Errorf("%s %s: expected an error, got %s", test.geometryType, test.coordinates, data)
+		}
+	}
+}
+
+func TestDatasetAxisOrder(t *testing.T) {
+	tests := []struct {
+		name       string
+		properties map[string]any
+		references map[string]any
+		geojson    string
+	}{
+		{
+			name:       "flatgeo point",
+			properties: map[string]any{flatgeoCoordinatesPredicate: []any{32.35, 34.79}},
+			references: map[string]any{flatgeoGeotypePredicate: "http://data.mimiro.io/models/flatgeo/Point"},
+			geojson:    `{"type":"Point","coordinates":[34.79,32.35]}`,
+		},
+		{
+			name:       "flatgeo polygon, rewound after swapping",
+			properties: map[string]any{flatgeoCoordinatesPredicate: []any{0.0, 0.0, 0.0, 1.0, 1.0, 1.0, 1.0, 0.0}},
+			references: map[string]any{flatgeoGeotypePredicate: "http://data.mimiro.io/models/flatgeo/Polygon"},
+			geojson:    `{"type":"Polygon","coordinates":[[[0,0],[1,0],[1,1],[0,1],[0,0]]]}`,
+		},
+		{
+			name:       "wkt collection",
+			properties: map[string]any{flatgeoWktPredicate: "GEOMETRYCOLLECTION (POINT Z (60 10 5), LINESTRING (60 10, 61 11))"},
+			geojson:    `{"type":"GeometryCollection","geometries":[{"type":"Point","coordinates":[10,60,5]},{"type":"LineString","coordinates":[[10,60],[11,61]]}]}`,
+		},
+		{
+			name:       "latitude and longitude properties are named, so they are not swapped",
+			properties: map[string]any{"http://data.example.org/Lat": 60.0, "http://data.example.org/Long": 10.0},
+			geojson:    `{"type":"Point","coordinates":[10,60]}`,
+		},
+	}
+	ds := &Dataset{Name: "test", AxisOrder: axisOrderLatLon, LatitudeProperty: "Lat", LongitudeProperty: "Long"}
+	for _, test := range tests {
+		e := testEntity("1", test.properties)
+		if test.references != nil {
+			e.References = test.references
+		}
+		g, err := makeGeomentryFromEntity(e, ds, e)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if data, _ := json.Marshal(g); string(data) != test.geojson {
+			t.Errorf("%s: got %s, expected %s", test.name, data, test.geojson)
+		}
+	}
+}
+
+func TestAxisOrderWarning(t *testing.T) {
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	ds := &Dataset{Name: "swapped"}
+	for _, wkt := range []string{"POINT (10 60)", "POINT (60 100)", "POINT (60 -100)"} {
+		e := testEntity("1", map[string]any{flatgeoWktPredicate: wkt})
+		if _, err := makeGeomentryFromEntity(e, ds, e); err != nil {
+			t.Fatalf("%s: %v", wkt, err)
+		}
+		if wkt == "POINT (10 60)" && logged.Len() != 0 {
+			t.Errorf("a latitude within ±90 should not be warned about, got %s", logged.String())
+		}
+	}
+	// the warning is given once per dataset
+	if lines := strings.Count(logged.String(), "\n"); lines != 1 || !strings.Contains(logged.String(), "axisOrder") {
+		t.Errorf("expected one warning about the axisOrder setting, got %q", logged.String())
+	}
+}
+
+func TestPointFromProperties(t *testing.T) {
+	tests := []struct {
+		name       string
+		properties map[string]any
+		geojson    string
+	}{
+		{
+			name:       "numbers",
+			properties: map[string]any{"http://data.example.org/Lat.": 32.35, "http://data.example.org/Long.": 34.79},
+			geojson:    `{"type":"Point","coordinates":[34.79,32.35]}`,
+		},
+		{
+			name:       "strings with a decimal comma",
+			properties: map[string]any{"http://data.example.org/Lat.": "32,35", "http://data.example.org/Long.": " 34.79"},
+			geojson:    `{"type":"Point","coordinates":[34.79,32.35]}`,
+		},
+		{
+			name:       "single valued lists and a depth",
+			properties: map[string]any{"http://data.example.org/Lat.": []any{"32.35"}, "http://data.example.org/Long.": []any{34.79}, "http://data.example.org/Depth": "50"},
+			geojson:    `{"type":"Point","coordinates":[34.79,32.35,-50]}`,
+		},
+		{name: "no latitude", properties: map[string]any{"http://data.example.org/Long.": 34.79}},
+		{name: "not a number", properties: map[string]any{"http://data.example.org/Lat.": "north", "http://data.example.org/Long.": 34.79}},
+		{name: "not a number", properties: map[string]any{"http://data.example.org/Lat.": "NaN", "http://data.example.org/Long.": 34.79}},
+		{name: "infinite", properties: map[string]any{"http://data.example.org/Lat.": 32.35, "http://data.example.org/Long.": "+Infinity"}},
+		{name: "digit grouping", properties: map[string]any{"http://data.example.org/Lat.": 32.35, "http://data.example.org/Long.": "1,234"}},
+	}
+	ds := &Dataset{Name: "test", LatitudeProperty: "Lat.", LongitudeProperty: "Long.", DepthProperty: "Depth"}
+	for _, test := range tests {
+		e := testEntity("1", test.properties)
+		g, err := makeGeomentryFromEntity(e, ds, e)
+		if test.geojson == "" {
+			if err == nil {
+				data, _ := json.Marshal(g)
+				t.Errorf("%s: expected an error, got %s", test.name, data)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if data, _ := json.Marshal(g); string(data) != test.geojson {
+			t.Errorf("%s: got %s, expected %s", test.name, data, test.geojson)
+		}
+	}
+}
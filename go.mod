@@ -2,8 +2,9 @@ module mimiro.io/ogc-uda-service
 
 go 1.19
 
+require github.com/labstack/echo/v4 v4.10.0
+
 require (
-	github.com/labstack/echo/v4 v4.10.0 // indirect
 	github.com/labstack/gommon v0.4.0 // indirect
 	github.com/mattn/go-colorable v0.1.13 // indirect
 	github.com/mattn/go-isatty v0.0.16 // indirect
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	referencesLinks      = "links"
+	referencesProperties = "properties"
+	referencesNone       = "none"
+)
+
+var referenceModes = map[string]bool{referencesLinks: true, referencesProperties: true, referencesNone: true}
+
+// Link is a web link as used by OGC API Features
+type Link struct {
+	Href  string `json:"href"`
+	Rel   string `json:"rel"`
+	Type  string `json:"type,omitempty"`
+	Title string `json:"title,omitempty"`
+}
+
+// structural references that are already part of the geometry or the collection
+var flatgeoReferences = map[string]bool{flatgeoGeotypePredicate: true, flatgeoFeaturesPredicate: true}
+
+// referenceMode is how the dataset publishes the references of its entities
+func (ds *Dataset) referenceMode() string {
+	if ds.References == "" {
+		return referencesLinks
+	}
+	return ds.References
+}
+
+// requestBaseUrl is the configured baseUrl of the service, or the scheme and host of the request
+func requestBaseUrl(c echo.Context) string {
+	if BaseUrl != "" {
+		return strings.TrimSuffix(BaseUrl, "/")
+	}
+	return c.Scheme() + "://" + c.Request().Host
+}
+
+// resolveReference turns a reference into a URL. References to entities of a published
+// dataset, found by its idNamespace, point at the feature on this service, served by getFeature.
+// Other references are returned as they are.
+func resolveReference(ref string, baseUrl string) string {
+	for _, ds := range RemoteDatahub.Datasets {
+		if ds.IdNamespace != "" && len(ref) > len(ds.IdNamespace) && strings.HasPrefix(ref, ds.IdNamespace) {
+			return baseUrl + "/datasets/" + url.PathEscape(ds.Name) + "/features/" +
+				url.PathEscape(strings.TrimPrefix(ref, ds.IdNamespace))
+		}
+	}
+	return ref
+}
+
+// addFeatureReferences publishes the references of the entity on the feature, as links or
+// as properties depending on the reference mode of the dataset. Property keys and link titles
+// are named as the properties of the dataset, and link relations are the full predicate URIs,
+// as extension relations must be URIs.
+func addFeatureReferences(f *Feature, e *Entity, ds *Dataset, namer *propertyNamer, baseUrl string) {
+	switch ds.referenceMode() {
+	case referencesProperties:
+		addReferenceProperties(f.Properties, e, ds, namer, baseUrl)
+	case referencesLinks:
+		pm := ds.propertyMapping()
+		for _, k := range referenceKeys(e, pm) {
+			for _, ref := range e.getReferenceValues(k) {
+				f.Links = append(f.Links, &Link{Href: resolveReference(ref, baseUrl), Rel: k, Title: namer.key(k, pm)})
+			}
+		}
+	}
+}
+
+// addReferenceProperties publishes the references of the entity as properties holding a URL,
+// or a list of URLs for references with several values
+func addReferenceProperties(properties map[string]interface{}, e *Entity, ds *Dataset, namer *propertyNamer, baseUrl string) {
+	pm := ds.propertyMapping()
+	for _, k := range referenceKeys(e, pm) {
+		refs := e.getReferenceValues(k)
+		name := namer.key(k, pm)
+
+		// properties keep their value when a reference has the same key
+		if _, taken := properties[name]; taken {
+			ds.warnEvery("reference key", "reference %s has the key %s of a property and is not published", k, name)
+			continue
+		}
+		if len(refs) == 1 {
+			properties[name] = resolveReference(refs[0], baseUrl)
+			continue
+		}
+		urls := make([]string, 0, len(refs))
+		for _, ref := range refs {
+			urls = append(urls, resolveReference(ref, baseUrl))
+		}
+		properties[name] = urls
+	}
+}
+
+// referenceKeys are the sorted predicates of the published references of the entity
+func referenceKeys(e *Entity, pm *PropertyMapping) []string {
+	keys := make([]string, 0, len(e.References))
+	for k := range e.References {
+		if !flatgeoReferences[k] && pm.isPublished(k) && len(e.getReferenceValues(k)) > 0 {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
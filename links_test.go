@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestResolveReference(t *testing.T) {
+	serveDatahub(t, &fakeDatahub{})
+	RemoteDatahub.Datasets = []*Dataset{
+		{Name: "species", IdNamespace: "http://data.example.org/species/"},
+		{Name: "sea areas", IdNamespace: "http://data.example.org/areas/"},
+	}
+	tests := []struct {
+		ref string
+		url string
+	}{
+		{ref: "http://data.example.org/species/1", url: "http://localhost/datasets/species/features/1"},
+		{ref: "http://data.example.org/areas/north/1", url: "http://localhost/datasets/sea%20areas/features/north%2F1"},
+		{ref: "http://data.example.org/species/", url: "http://data.example.org/species/"},
+		{ref: "http://other.example.org/species/1", url: "http://other.example.org/species/1"},
+	}
+	for _, test := range tests {
+		if url := resolveReference(test.ref, "http://localhost"); url != test.url {
+			t.Errorf("%s: got %s, expected %s", test.ref, url, test.url)
+		}
+	}
+}
+
+func TestFeatureReferences(t *testing.T) {
+	serveDatahub(t, &fakeDatahub{})
+	RemoteDatahub.Datasets = []*Dataset{{Name: "species", IdNamespace: "http://data.example.org/species/"}}
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	const (
+		species = "http://data.example.org/species"
+		area    = "http://data.example.org/area"
+		source  = "http://data.example.org/source"
+		hidden  = "http://data.example.org/hidden"
+	)
+	e := testEntity("1", map[string]any{"http://data.example.org/Name": "Aurelia aurita"})
+	e.References = map[string]any{
+		species:                 []string{"http://data.example.org/species/1", "http://data.example.org/species/2"},
+		area:                    "http://other.example.org/areas/north",
+		hidden:                  "http://data.example.org/species/3",
+		flatgeoGeotypePredicate: "http://data.mimiro.io/models/flatgeo/Point",
+	}
+	mapping := &PropertyMapping{Exclude: []string{"hidden"}}
+	namer := &propertyNamer{mode: propertyKeysLocal, context: NewEntityCollection().Context, colliding: map[string]bool{}}
+
+	// links are sorted by predicate, titled by the property key and related by the predicate URI
+	ds := &Dataset{Name: "links", PropertyMapping: mapping}
+	f := &Feature{Properties: map[string]any{}}
+	addFeatureReferences(f, e, ds, namer, "http://localhost")
+	links := []*Link{
+		{Href: "http://other.example.org/areas/north", Rel: area, Title: "area"},
+		{Href: "http://localhost/datasets/species/features/1", Rel: species, Title: "species"},
+		{Href: "http://localhost/datasets/species/features/2", Rel: species, Title: "species"},
+	}
+	if !reflect.DeepEqual(f.Links, links) {
+		t.Errorf("links: got %+v, expected %+v", f.Links, links)
+	}
+	if len(f.Properties) != 0 {
+		t.Errorf("links: got properties %v", f.Properties)
+	}
+
+	// properties hold a URL, or a list of URLs for references with several values
+	ds = &Dataset{Name: "properties", References: referencesProperties, PropertyMapping: mapping}
+	f = &Feature{Properties: map[string]any{}}
+	addFeatureReferences(f, e, ds, namer, "http://localhost")
+	properties := map[string]any{
+		"area":    "http://other.example.org/areas/north",
+		"species": []string{"http://localhost/datasets/species/features/1", "http://localhost/datasets/species/features/2"},
+	}
+	if !reflect.DeepEqual(f.Properties, properties) || f.Links != nil {
+		t.Errorf("properties: got %v and links %v, expected %v", f.Properties, f.Links, properties)
+	}
+
+	// a property keeps its key when a reference has the same key
+	f = &Feature{Properties: map[string]any{"area": "North Sea"}}
+	addFeatureReferences(f, e, ds, namer, "http://localhost")
+	if f.Properties["area"] != "North Sea" {
+		t.Errorf("the reference replaced the property area: %v", f.Properties["area"])
+	}
+	if !strings.Contains(buf.String(), "reference "+area+" has the key area of a property") {
+		t.Errorf("the hidden reference was not logged: %q", buf.String())
+	}
+
+	ds = &Dataset{Name: "none", References: referencesNone, PropertyMapping: mapping}
+	f = &Feature{Properties: map[string]any{}}
+	addFeatureReferences(f, e, ds, namer, "http://localhost")
+	if f.Links != nil || len(f.Properties) != 0 {
+		t.Errorf("none: got links %v and properties %v", f.Links, f.Properties)
+	}
+}
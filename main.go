@@ -4,9 +4,14 @@ import (
 	"encoding/json"
 	"errors"
 	"io/ioutil"
+	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/labstack/echo/v4"
 )
@@ -19,25 +24,205 @@ type Datahub struct {
 type Dataset struct {
 	Name              string `json:"name"`
 	Type              string `json:"type"`
+	Title             string `json:"title,omitempty"`
+	Description       string `json:"description,omitempty"`
 	RemoteDataset     string `json:"remoteName"`
 	StripPropertyUrls bool   `json:"stripPropertyUrls"`
+	PropertyKeys      string `json:"propertyKeys,omitempty"`
+	References        string `json:"references,omitempty"`
+	IdNamespace       string `json:"idNamespace,omitempty"`
+	GeometryReference string `json:"geometryReference,omitempty"`
+	GeometryDataset   string `json:"geometryDataset,omitempty"`
+	AxisOrder         string `json:"axisOrder,omitempty"`
+	LatitudeProperty  string `json:"latitudeProperty,omitempty"`
+	LongitudeProperty string `json:"longitudeProperty,omitempty"`
+	DepthProperty     string `json:"depthProperty,omitempty"`
+	ElevationProperty string `json:"elevationProperty,omitempty"`
+	WktProperty       string `json:"wktProperty,omitempty"`
+	WkbProperty       string `json:"wkbProperty,omitempty"`
+	SourceCrs         string `json:"sourceCrs,omitempty"`
+	Dimensions        int    `json:"dimensions,omitempty"`
+
+	TimeProperties  []*TimeProperty  `json:"timeProperties,omitempty"`
+	PropertyMapping *PropertyMapping `json:"properties,omitempty"`
+
+	sourceCrs        *CoordinateReferenceSystem
+	axisOrderWarning sync.Once
+	// sampledDimensions are the dimensions of the geometries found when the dataset was sampled,
+	// 0 until then, guarded by dimensionsMu
+	sampledDimensions int
+	dimensionsMu      sync.Mutex
+	dimensionsSample  datasetSample
+	// propertyKeyOwners are the URIs of the properties published by their local key, by the key,
+	// and collidingProperties the URIs published by their CURIE, see assignPropertyKeys
+	propertyKeyOwners   map[string]string
+	collidingProperties map[string]bool
+	propertyKeysMu      sync.Mutex
+	propertyKeysSample  datasetSample
+	// seenProperties are the URIs of the published properties seen in the entities
+	seenProperties sync.Map
+	// schemaSample are the features property types are inferred from, read at schemaSampled
+	schemaSample      []*Feature
+	schemaSampled     time.Time
+	schemaSampleMu    sync.Mutex
+	warnings          sync.Map
+	recurringWarnings sync.Map
+}
+
+// warnOnce logs a warning about the dataset the first time it happens for the given key
+func (ds *Dataset) warnOnce(key string, format string, args ...any) {
+	if _, warned := ds.warnings.LoadOrStore(key, true); !warned {
+		log.Printf("dataset "+ds.Name+": "+format, args...)
+	}
+}
+
+// warningInterval is how often a recurring warning, such as a failing conversion, is repeated
+const warningInterval = time.Minute
+
+// warnEvery logs a warning about the dataset at most once every warningInterval for the given
+// key, counting the warnings left out in between
+func (ds *Dataset) warnEvery(key string, format string, args ...any) {
+	now := time.Now()
+	v, _ := ds.recurringWarnings.LoadOrStore(key, &recurringWarning{})
+	w := v.(*recurringWarning)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.logged.IsZero() && now.Sub(w.logged) < warningInterval {
+		w.suppressed++
+		return
+	}
+	if w.suppressed > 0 {
+		format += " (%d more since the last warning)"
+		args = append(args, w.suppressed)
+	}
+	log.Printf("dataset "+ds.Name+": "+format, args...)
+	w.logged = now
+	w.suppressed = 0
+}
+
+type recurringWarning struct {
+	mu         sync.Mutex
+	logged     time.Time
+	suppressed int
+}
+
+// MarshalJSON reports the dimensions of the dataset geometries next to its configuration
+func (ds *Dataset) MarshalJSON() ([]byte, error) {
+	type dataset Dataset
+	return json.Marshal(struct {
+		*dataset
+		Dimensions int `json:"dimensions"`
+	}{(*dataset)(ds), ds.dimensions()})
+}
+
+// dimensions is 3 for datasets with depth or elevation and 2 for flat datasets. Unless it is
+// configured it is derived from the depth and elevation settings and the sampled geometries.
+// It never waits for the sample, so that listing the datasets does not depend on the UDA endpoint.
+func (ds *Dataset) dimensions() int {
+	if ds.Dimensions != 0 {
+		return ds.Dimensions
+	}
+	if ds.DepthProperty != "" || ds.ElevationProperty != "" || ds.sampleDimensions() == 3 {
+		return 3
+	}
+	return 2
+}
+
+// sampleRetryInterval is how long a sample of a dataset that failed is not tried again
+const sampleRetryInterval = time.Minute
+
+// datasetSample is something learned once from the first entities of a dataset, such as the
+// dimensions of its geometries. It is read the first time it is needed, and read again when it
+// is needed after sampleRetryInterval when reading it failed. A sample is either waited for with
+// ensure or read with ensureInBackground, never both.
+type datasetSample struct {
+	mu      sync.Mutex
+	done    bool
+	running bool
+	failed  time.Time
+}
+
+// ensure reads the sample unless it is read, or failed less than sampleRetryInterval ago. The
+// fields read sets are only to be used after ensure returns.
+func (s *datasetSample) ensure(ds *Dataset, what string, read func() error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.done || s.failedRecently() {
+		return
+	}
+	if err := read(); err != nil {
+		s.fail(ds, what, err)
+		return
+	}
+	s.done = true
+}
+
+// ensureInBackground starts reading the sample unless it is read, being read, or failed less than
+// sampleRetryInterval ago, and returns without waiting for it. read runs without the lock of the
+// sample held, so it must guard the fields it sets with a lock of its own.
+func (s *datasetSample) ensureInBackground(ds *Dataset, what string, read func() error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.done || s.running || s.failedRecently() {
+		return
+	}
+	s.running = true
+	go func() {
+		err := read()
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.running = false
+		if err != nil {
+			s.fail(ds, what, err)
+			return
+		}
+		s.done = true
+	}()
+}
+
+func (s *datasetSample) failedRecently() bool {
+	return !s.failed.IsZero() && time.Since(s.failed) < sampleRetryInterval
+}
+
+func (s *datasetSample) fail(ds *Dataset, what string, err error) {
+	log.Printf("dataset %s: unable to sample the %s, trying again in %v: %v", ds.Name, what, sampleRetryInterval, err)
+	s.failed = time.Now()
 }
 
 var RemoteDatahub *Datahub
 
+// BaseUrl is the public URL of this service, used in links. When empty the URL of the request is used.
+var BaseUrl string
+
 func main() {
 	RemoteDatahub = &Datahub{}
 	RemoteDatahub.Datasets = make([]*Dataset, 0)
 
 	loadConfig()
+	// the dimensions are sampled in the background, so that they are known by the first request
+	for _, ds := range RemoteDatahub.Datasets {
+		ds.dimensions()
+	}
 
 	e := echo.New()
+	e.GET("/health", getHealth)
 	e.GET("/datasets", getDatasets)
 	e.GET("/datasets/:dataset", getDataset)
 	e.GET("/datasets/:dataset/changes", getChanges)
-	e.GET("/", func(c echo.Context) error {
-		return c.String(http.StatusOK, "Running")
-	})
+	// references to entities of published datasets resolve to this route, see resolveReference
+	e.GET("/datasets/:dataset/features/:id", getFeature)
+
+	// OGC API - Features
+	e.GET("/", getLandingPage)
+	e.GET("/conformance", getConformance)
+	e.GET("/api", getAPI)
+	e.GET("/api.html", getAPIDocumentation)
+	e.GET("/collections", getCollections)
+	e.GET("/collections/:collection", getCollection)
+	e.GET("/collections/:collection/items", getItems)
+	e.GET("/collections/:collection/items/:featureId", getItem)
+	e.GET("/collections/:collection/queryables", getQueryables)
+	e.GET("/collections/:collection/schema", getFeatureSchema)
 	e.Logger.Fatal(e.Start(":9042"))
 }
 
@@ -55,13 +240,105 @@ func loadConfig() {
 	json.Unmarshal([]byte(byteResult), &res)
 
 	RemoteDatahub.Url = res["uda"].(string)
+	if res["baseUrl"] != nil {
+		BaseUrl = res["baseUrl"].(string)
+	}
 	RemoteDatahub.Datasets = make([]*Dataset, 0)
 	for _, ds := range res["datasets"].([]interface{}) {
 		dsmap := ds.(map[string]interface{})
 		newDataset := &Dataset{Name: dsmap["name"].(string), Type: dsmap["type"].(string), RemoteDataset: dsmap["remoteName"].(string)}
+		if dsmap["title"] != nil {
+			newDataset.Title = dsmap["title"].(string)
+		}
+		if dsmap["description"] != nil {
+			newDataset.Description = dsmap["description"].(string)
+		}
 		if dsmap["stripPropertyUrls"] != nil {
 			newDataset.StripPropertyUrls = dsmap["stripPropertyUrls"].(bool)
 		}
+		if dsmap["propertyKeys"] != nil {
+			newDataset.PropertyKeys = dsmap["propertyKeys"].(string)
+			if !propertyKeyModes[newDataset.PropertyKeys] {
+				panic("unknown propertyKeys " + newDataset.PropertyKeys + " for dataset " + newDataset.Name)
+			}
+		}
+		if dsmap["references"] != nil {
+			newDataset.References = dsmap["references"].(string)
+			if !referenceModes[newDataset.References] {
+				panic("unknown references " + newDataset.References + " for dataset " + newDataset.Name)
+			}
+		}
+		if dsmap["idNamespace"] != nil {
+			newDataset.IdNamespace = dsmap["idNamespace"].(string)
+		}
+		if dsmap["geometryReference"] != nil {
+			newDataset.GeometryReference = dsmap["geometryReference"].(string)
+		}
+		if dsmap["geometryDataset"] != nil {
+			newDataset.GeometryDataset = dsmap["geometryDataset"].(string)
+		}
+		if dsmap["axisOrder"] != nil {
+			newDataset.AxisOrder = dsmap["axisOrder"].(string)
+			if newDataset.AxisOrder != axisOrderLonLat && newDataset.AxisOrder != axisOrderLatLon {
+				panic("unknown axisOrder " + newDataset.AxisOrder + " for dataset " + newDataset.Name)
+			}
+		}
+		if dsmap["latitudeProperty"] != nil {
+			newDataset.LatitudeProperty = dsmap["latitudeProperty"].(string)
+		}
+		if dsmap["longitudeProperty"] != nil {
+			newDataset.LongitudeProperty = dsmap["longitudeProperty"].(string)
+		}
+		if dsmap["depthProperty"] != nil {
+			newDataset.DepthProperty = dsmap["depthProperty"].(string)
+		}
+		if dsmap["elevationProperty"] != nil {
+			newDataset.ElevationProperty = dsmap["elevationProperty"].(string)
+		}
+		if dsmap["wktProperty"] != nil {
+			newDataset.WktProperty = dsmap["wktProperty"].(string)
+		}
+		if dsmap["wkbProperty"] != nil {
+			newDataset.WkbProperty = dsmap["wkbProperty"].(string)
+		}
+		if dsmap["dimensions"] != nil {
+			newDataset.Dimensions = int(dsmap["dimensions"].(float64))
+			if newDataset.Dimensions != 2 && newDataset.Dimensions != 3 {
+				panic("dimensions must be 2 or 3 for dataset " + newDataset.Name)
+			}
+		}
+		if dsmap["timeProperties"] != nil {
+			for _, tp := range dsmap["timeProperties"].([]interface{}) {
+				tpmap := tp.(map[string]interface{})
+				timeProperty := &TimeProperty{Property: tpmap["property"].(string)}
+				if tpmap["format"] != nil {
+					timeProperty.Format = tpmap["format"].(string)
+				}
+				newDataset.TimeProperties = append(newDataset.TimeProperties, timeProperty)
+			}
+			if len(newDataset.TimeProperties) > 2 {
+				panic("at most two timeProperties, start and end, for dataset " + newDataset.Name)
+			}
+		}
+		if dsmap["properties"] != nil {
+			// the mapping is nested, so decode it through its json form
+			mappingJson, _ := json.Marshal(dsmap["properties"])
+			newDataset.PropertyMapping = &PropertyMapping{}
+			if err := json.Unmarshal(mappingJson, newDataset.PropertyMapping); err != nil {
+				panic("invalid properties for dataset " + newDataset.Name + ": " + err.Error())
+			}
+			if err := newDataset.PropertyMapping.validate(); err != nil {
+				panic(err.Error() + " for dataset " + newDataset.Name)
+			}
+		}
+		if dsmap["sourceCrs"] != nil {
+			newDataset.SourceCrs = dsmap["sourceCrs"].(string)
+			sourceCrs, err := lookupCRS(newDataset.SourceCrs)
+			if err != nil {
+				panic(err.Error() + " for dataset " + newDataset.Name)
+			}
+			newDataset.sourceCrs = sourceCrs
+		}
 		RemoteDatahub.Datasets = append(RemoteDatahub.Datasets, newDataset)
 	}
 }
@@ -75,6 +352,11 @@ func lookupDataset(name string) *Dataset {
 	return nil
 }
 
+// getHealth tells that the service is running, as / did before it became the landing page
+func getHealth(c echo.Context) error {
+	return c.String(http.StatusOK, "Running")
+}
+
 // return the datasets in the remote datahub as json
 func getDatasets(c echo.Context) error {
 	return c.JSON(http.StatusOK, RemoteDatahub.Datasets)
@@ -104,45 +386,268 @@ func getChanges(c echo.Context) error {
 		return c.NoContent(http.StatusNotFound)
 	}
 
+	// features are published in WGS84 unless another crs is asked for
+	var targetCrs *CoordinateReferenceSystem
+	if c.QueryParam("crs") != "" {
+		var err error
+		targetCrs, err = lookupCRS(c.QueryParam("crs"))
+		if err != nil {
+			return c.String(http.StatusBadRequest, err.Error())
+		}
+		c.Response().Header().Set("Content-Crs", "<"+targetCrs.URI+">")
+	}
+
+	limit, err := parseLimit(c.QueryParam("limit"), upstreamPageSize, upstreamPageSize)
+	if err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+	filters, err := parseFeatureFilters(c, ds, changesParameters)
+	if err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+
 	// get the changes from the remote datahub
-	requestURL := RemoteDatahub.Url + "/datasets/" + ds.RemoteDataset + "/changes"
+	ec, err := fetchChanges(ds.RemoteDataset, since, limit)
+	if err != nil {
+		return upstreamErrorResponse(c, err)
+	}
+
+	baseUrl := requestBaseUrl(c)
+	if ds.Type == "features" {
+		geoJson, _ := convertToFeatures(ec, ds, baseUrl)
+		geoJson = filterChanges(geoJson, filters)
+		geoJson = projectFeatures(geoJson, targetCrs, ds)
+		return c.JSON(http.StatusOK, geoJson)
+	} else if ds.Type == "featurecollections" {
+		geoJson, _ := convertToFeatureCollections(ec, ds, baseUrl)
+		geoJson = filterChanges(geoJson, filters)
+		geoJson = projectFeatures(geoJson, targetCrs, ds)
+		return c.JSON(http.StatusOK, geoJson)
+	}
+	return c.NoContent(http.StatusBadRequest)
+}
+
+// getFeature returns the current version of one feature of the dataset by its id. Collections
+// of featurecollections datasets are not looked up, the features in them are.
+func getFeature(c echo.Context) error {
+	ds := lookupDataset(c.Param("dataset"))
+	if ds == nil {
+		return c.NoContent(http.StatusNotFound)
+	}
+
+	var targetCrs *CoordinateReferenceSystem
+	if c.QueryParam("crs") != "" {
+		var err error
+		targetCrs, err = lookupCRS(c.QueryParam("crs"))
+		if err != nil {
+			return c.String(http.StatusBadRequest, err.Error())
+		}
+		c.Response().Header().Set("Content-Crs", "<"+targetCrs.URI+">")
+	}
+
+	id, err := url.PathUnescape(c.Param("id"))
+	if err != nil {
+		return c.NoContent(http.StatusNotFound)
+	}
+	f, err := readFeature(ds, id, requestBaseUrl(c))
+	if err != nil {
+		return upstreamErrorResponse(c, err)
+	}
+	if f == nil {
+		return c.NoContent(http.StatusNotFound)
+	}
+	if len(projectFeatures([]any{f}, targetCrs, ds)) == 0 {
+		return c.String(http.StatusBadRequest, "the feature lies outside the area of "+targetCrs.Code)
+	}
+	return c.JSON(http.StatusOK, f)
+}
+
+// upstreamError is a response from the UDA endpoint with a status other than 200
+type upstreamError struct {
+	StatusCode int
+}
+
+func (e *upstreamError) Error() string {
+	return "uda endpoint responded with status " + strconv.Itoa(e.StatusCode)
+}
+
+// entityCacheRetryAfter is the number of seconds clients are asked to wait for an entity cache
+// that is loaded for the first time
+const entityCacheRetryAfter = "5"
+
+// upstreamErrorResponse passes on the status of the UDA endpoint, or reports a failure to reach it.
+// Lookups made while the entities of the dataset are loaded are asked to come back later.
+func upstreamErrorResponse(c echo.Context, err error) error {
+	if errors.Is(err, errEntityCacheLoading) {
+		c.Response().Header().Set("Retry-After", entityCacheRetryAfter)
+		return c.String(http.StatusServiceUnavailable, err.Error())
+	}
+	var upstream *upstreamError
+	if errors.As(err, &upstream) {
+		return c.NoContent(upstream.StatusCode)
+	}
+	return c.String(http.StatusInternalServerError, err.Error())
+}
+
+// upstreamTimeout bounds a request to the UDA endpoint, including reading the response
+const upstreamTimeout = 30 * time.Second
+
+var upstreamClient = &http.Client{Timeout: upstreamTimeout}
+
+// fetchChanges reads one page of at most limit latest changes of a dataset in the remote datahub
+func fetchChanges(remoteDataset string, since string, limit int) (*EntityCollection, error) {
+	requestURL := RemoteDatahub.Url + "/datasets/" + remoteDataset + "/changes"
 
 	if since != "" {
-		requestURL += "?since=" + since + "&limit=1000&latestOnly=true"
+		requestURL += "?since=" + url.QueryEscape(since) + "&limit=" + strconv.Itoa(limit) + "&latestOnly=true"
 	} else {
-		requestURL += "?latestOnly=true&limit=1000"
+		requestURL += "?latestOnly=true&limit=" + strconv.Itoa(limit)
 	}
-	res, err := http.Get(requestURL)
+	res, err := upstreamClient.Get(requestURL)
 	if err != nil {
-		return c.String(http.StatusInternalServerError, err.Error())
-	} else {
-		if res.StatusCode == http.StatusOK {
-			defer res.Body.Close()
-			parser := NewEntityParser()
-			ec, err := parser.Parse(res.Body)
-			if err != nil {
-				return c.String(http.StatusInternalServerError, err.Error())
-			}
-			if ds.Type == "features" {
-				geoJson, _ := convertToFeatures(ec, ds.StripPropertyUrls)
-				return c.JSON(http.StatusOK, geoJson)
-			} else if ds.Type == "featureCollections" {
-				geoJson, _ := convertToFeatureCollections(ec)
-				return c.JSON(http.StatusOK, geoJson)
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, &upstreamError{StatusCode: res.StatusCode}
+	}
+	parser := NewEntityParser()
+	return parser.Parse(res.Body)
+}
+
+const (
+	rdfTypePredicate          = "http://www.w3.org/1999/02/22-rdf-syntax-ns#type"
+	flatgeoGeotypePredicate   = "http://data.mimiro.io/models/flatgeo/geotype"
+	flatgeoFeaturesPredicate  = "http://data.mimiro.io/models/flatgeo/features"
+	flatgeoBboxPredicate      = "http://data.mimiro.io/models/flatgeo/bbox"
+	flatgeoAssetTypePredicate = "http://data.mimiro.io/models/flatgeo/assetType"
+	flatgeoAssetLinkPredicate = "http://data.mimiro.io/models/flatgeo/assetLink"
+	flatgeoFeatureCollection  = "http://data.mimiro.io/models/flatgeo/FeatureCollection"
+)
+
+// func to convert from UDA feature collection entities to GeoJSON FeatureCollections.
+// Member features are resolved through the flatgeo/features references against the
+// entities in the same batch, and otherwise against the cache of the dataset.
+func convertToFeatureCollections(ec *EntityCollection, ds *Dataset, baseUrl string) ([]any, error) {
+	collections := make([]any, 0)
+
+	// add empty context object
+	context := make(map[string]interface{})
+	context["id"] = "@context"
+	collections = append(collections, context)
+
+	// index all entities so that members can be resolved by id
+	entitiesById := make(map[string]*Entity)
+	for _, e := range ec.Entities {
+		entitiesById[e.ID] = e
+	}
+	members := resolveMembers(ec, ds, entitiesById)
+	for _, member := range members {
+		entitiesById[member.ID] = member
+	}
+
+	// members from the cache are named and resolved together with the entities of the batch
+	batchEntities := &EntityCollection{Context: ec.Context, Entities: append(append([]*Entity{}, ec.Entities...), members...)}
+	batch := newFeatureBatch(batchEntities, ds, baseUrl)
+
+	for _, e := range ec.Entities {
+		if !isFeatureCollectionEntity(e) {
+			continue
+		}
+
+		fc := &FeatureCollection{}
+		fc.Id = e.ID
+		fc.IsDeleted = e.IsDeleted
+		fc.Type = "FeatureCollection"
+		fc.Features = make([]*Feature, 0)
+		fc.AssetType, _ = e.getStringLiteralPropertyValue(flatgeoAssetTypePredicate)
+		fc.AssetLink, _ = e.getStringLiteralPropertyValue(flatgeoAssetLinkPredicate)
+
+		memberBoundingBoxes := make([][]float64, 0)
+		if !e.IsDeleted {
+			for _, memberId := range e.getReferenceValues(flatgeoFeaturesPredicate) {
+				member, found := entitiesById[memberId]
+				if !found || member.IsDeleted {
+					continue
+				}
+				f := makeFeature(member, ds, batch)
+				fc.Features = append(fc.Features, f)
+				memberBoundingBoxes = append(memberBoundingBoxes, f.BoundingBox)
 			}
-			return c.NoContent(http.StatusBadRequest)
+		}
+
+		// the bbox on the collection entity wins over the extent of its members
+		bbox, err := e.getFloatListPropertyValue(flatgeoBboxPredicate)
+		if err == nil && isValidBoundingBox(bbox) {
+			fc.BoundingBox = bbox
 		} else {
-			return c.NoContent(res.StatusCode)
+			fc.BoundingBox = mergeBoundingBoxes(memberBoundingBoxes)
 		}
+
+		collections = append(collections, fc)
+	}
+
+	// continuation token
+	if ec.Continuation != nil && ec.Continuation.Token != "" {
+		contination := make(map[string]interface{})
+		contination["id"] = "@continuation"
+		contination["token"] = ec.Continuation.Token
+		collections = append(collections, contination)
 	}
+
+	return collections, nil
 }
 
-func convertToFeatureCollections(ec *EntityCollection) ([]*FeatureCollection, error) {
-	return nil, nil
+// resolveMembers looks up the members of the collections of the batch that are not part of it,
+// such as members that were not changed along with their collection, in the cache of the
+// dataset. Members that are not found there either are logged.
+func resolveMembers(ec *EntityCollection, ds *Dataset, entitiesById map[string]*Entity) []*Entity {
+	missing := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, e := range ec.Entities {
+		if !isFeatureCollectionEntity(e) || e.IsDeleted {
+			continue
+		}
+		for _, memberId := range e.getReferenceValues(flatgeoFeaturesPredicate) {
+			if _, found := entitiesById[memberId]; !found && !seen[memberId] {
+				seen[memberId] = true
+				missing = append(missing, memberId)
+			}
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	cached, err := lookupEntityCache(ds.RemoteDataset).getEntities(missing)
+	if errors.Is(err, errEntityCacheLoading) {
+		ds.warnEvery("members loading", "collections are published without the members outside the page while the entities of %s are loaded", ds.RemoteDataset)
+	} else if err != nil {
+		log.Printf("dataset %s: unable to load collection members from %s: %v", ds.Name, ds.RemoteDataset, err)
+	}
+	members := make([]*Entity, 0, len(cached))
+	for _, memberId := range missing {
+		if member, found := cached[memberId]; found {
+			members = append(members, member)
+		} else if err == nil {
+			ds.warnEvery("member", "member %s of a feature collection is not found in %s", memberId, ds.RemoteDataset)
+		}
+	}
+	return members
+}
+
+func isFeatureCollectionEntity(e *Entity) bool {
+	for _, t := range e.getReferenceValues(rdfTypePredicate) {
+		if t == flatgeoFeatureCollection {
+			return true
+		}
+	}
+	geometryType, _ := e.getReferenceValue(flatgeoGeotypePredicate)
+	return geometryType == flatgeoFeatureCollection
 }
 
 // func to convert from UDA to GeoJSON
-func convertToFeatures(ec *EntityCollection, stripPropertyUrls bool) ([]any, error) {
+func convertToFeatures(ec *EntityCollection, ds *Dataset, baseUrl string) ([]any, error) {
 	features := make([]any, 0)
 
 	// add empty context object
@@ -151,25 +656,13 @@ func convertToFeatures(ec *EntityCollection, stripPropertyUrls bool) ([]any, err
 	features = append(features, context)
 
 	// add all features
+	batch := newFeatureBatch(ec, ds, baseUrl)
 	for _, e := range ec.Entities {
-		f := &Feature{}
-		f.Id = e.ID
-		f.IsDeleted = e.IsDeleted
-		f.Type = "Feature"
-
-		f.Geometry, _ = makeGeomentryFromEntity(e)
-
-		// map all entity properties to the geojson properties
-		f.Properties = make(map[string]interface{})
-		for k, v := range e.Properties {
-			k = stripUrl(k)
-			f.Properties[k] = v
-		}
-		features = append(features, f)
+		features = append(features, makeFeature(e, ds, batch))
 	}
 
 	// continuation token
-	if ec.Continuation.Token != "" {
+	if ec.Continuation != nil && ec.Continuation.Token != "" {
 		contination := make(map[string]interface{})
 		contination["id"] = "@continuation"
 		contination["token"] = ec.Continuation.Token
@@ -179,59 +672,129 @@ func convertToFeatures(ec *EntityCollection, stripPropertyUrls bool) ([]any, err
 	return features, nil
 }
 
-func stripUrl(url string) string {
-	if strings.Contains(url, "#") {
-		return strings.Split(url, "#")[1]
-	} else if strings.Contains(url, "/") {
-		// find last slash in url
-		lastSlash := strings.LastIndex(url, "/")
-		return url[lastSlash+1:]
+func makeFeature(e *Entity, ds *Dataset, batch *featureBatch) *Feature {
+	f := &Feature{}
+	f.Id = e.ID
+	f.IsDeleted = e.IsDeleted
+	f.Type = "Feature"
+	if e.Recorded != 0 {
+		f.recorded = time.Unix(0, int64(e.Recorded)).UTC()
 	}
-	return url
-}
 
-func makeGeomentryFromEntity(e *Entity) (*Geometry, error) {
-	geotypePredicate := "http://data.mimiro.io/models/flatgeo/geotype"
-	pointType := "http://data.mimiro.io/models/flatgeo/Point"
-	polygonType := "http://data.mimiro.io/models/flatgeo/Polygon"
+	var err error
+	f.Geometry, err = makeGeomentryFromEntity(e, ds, batch.geometryEntity(e, ds))
+	if err != nil && !e.IsDeleted {
+		// deleted entities carry no geometry
+		ds.warnEvery("geometry", "entity %s: %v", e.ID, err)
+	}
 
-	g := &Geometry{}
-	g.Coordinates = make([]interface{}, 0)
+	f.Time, f.start, f.end, err = makeFeatureTime(e, ds)
+	if err != nil {
+		ds.warnEvery("time", "entity %s: %v", e.ID, err)
+	}
 
-	geometryType, _ := e.getReferenceValue(geotypePredicate)
-	if geometryType == pointType {
-		g.Type = "Point"
-		coords := e.Properties["http://data.mimiro.io/models/flatgeo/coordinates"]
-		for _, c := range coords.([]interface{}) {
-			g.Coordinates = append(g.Coordinates, c)
+	// use the bbox given on the entity when it is valid, otherwise compute it from the geometry.
+	// The bbox is in the CRS of the geometry, which may be given by the SRID of the geometry.
+	bbox, err := e.getFloatListPropertyValue(flatgeoBboxPredicate)
+	if err == nil && ds.AxisOrder == axisOrderLatLon {
+		bbox = swapBoundingBoxAxes(bbox)
+	}
+	bboxCrs := ds.sourceCrs
+	if f.Geometry != nil {
+		if crs, crsErr := ds.geometryCrs(f.Geometry); crsErr == nil {
+			bboxCrs = crs
 		}
-	} else if geometryType == polygonType {
-		g.Type = "Polygon"
-		coords := e.Properties["http://data.mimiro.io/models/flatgeo/coordinates"].([]interface{})
-		// iterate over list of coords and create a geojson pologon where there are 2 elements in each list
-		coordList := make([]interface{}, 0)
-		for i := 0; i < len(coords); i += 2 {
-			coordList = append(coordList, coords[i])
-			coordList = append(coordList, coords[i+1])
-			g.Coordinates = append(g.Coordinates, coordList)
+	}
+	if err == nil && bboxCrs != nil && !bboxCrs.isWGS84() {
+		bbox = transformBoundingBox(bbox, bboxCrs.toWGS84)
+	}
+	if err == nil && isValidBoundingBox(bbox) {
+		f.BoundingBox = bbox
+	} else {
+		f.BoundingBox = computeBoundingBox(f.Geometry)
+	}
 
-			// reset the coordList
-			coordList = make([]interface{}, 0)
+	// map all entity properties to the geojson properties
+	f.Properties = makeFeatureProperties(e, ds, batch.namer, batch.baseUrl)
+
+	// and the references to links or properties
+	addFeatureReferences(f, e, ds, batch.namer, batch.baseUrl)
+	return f
+}
+
+// featureBatch holds what the features made from one page of entities share
+type featureBatch struct {
+	namer   *propertyNamer
+	baseUrl string
+	// entities holding the geometry of the entities of the page, by id
+	geometries map[string]*Entity
+}
+
+func newFeatureBatch(ec *EntityCollection, ds *Dataset, baseUrl string) *featureBatch {
+	ds.observeProperties(ec)
+	return &featureBatch{
+		namer:      newPropertyNamer(ds, ec),
+		baseUrl:    baseUrl,
+		geometries: resolveGeometryEntities(ec, ds),
+	}
+}
+
+// projectFeatures converts the features, and the features of collections, into the target crs.
+// Features that lie outside the area of the crs are left out, as their positions would not be
+// finite. The bbox of a collection that lies outside the area is left out too.
+func projectFeatures(items []any, targetCrs *CoordinateReferenceSystem, ds *Dataset) []any {
+	if targetCrs == nil || targetCrs.URI == crs84URI {
+		return items
+	}
+	projected := make([]any, 0, len(items))
+	for _, item := range items {
+		switch v := item.(type) {
+		case *Feature:
+			if err := targetCrs.projectFeature(v); err != nil {
+				ds.warnOnce("projection "+targetCrs.Code, "%v, features outside the area are left out", err)
+				continue
+			}
+		case *FeatureCollection:
+			features := make([]*Feature, 0, len(v.Features))
+			for _, f := range v.Features {
+				if err := targetCrs.projectFeature(f); err != nil {
+					ds.warnOnce("projection "+targetCrs.Code, "%v, features outside the area are left out", err)
+					continue
+				}
+				features = append(features, f)
+			}
+			v.Features = features
+			if v.BoundingBox != nil {
+				v.BoundingBox = transformBoundingBox(v.BoundingBox, targetCrs.fromWGS84InAxisOrder)
+				if !allFinite(v.BoundingBox) {
+					v.BoundingBox = nil
+				}
+			}
 		}
-	} else {
-		return nil, errors.New("unknown geometry type: " + geometryType)
+		projected = append(projected, item)
 	}
+	return projected
+}
 
-	return g, nil
+func stripUrl(url string) string {
+	if strings.Contains(url, "#") {
+		return strings.Split(url, "#")[1]
+	} else if strings.Contains(url, "/") {
+		// find last slash in url
+		lastSlash := strings.LastIndex(url, "/")
+		return url[lastSlash+1:]
+	}
+	return url
 }
 
 type FeatureCollection struct {
-	Id          string    `json:"id"`
-	Type        string    `json:"type"`
-	BoundingBox []float64 `json:"bbox"`
-	AssetType   string    `json:"assetType,omitempty"`
-	AssetLink   string    `json:"assetLink,omitempty"`
-	IsDeleted   bool      `json:"isDeleted,omitempty"`
+	Id          string     `json:"id"`
+	Type        string     `json:"type"`
+	BoundingBox []float64  `json:"bbox"`
+	Features    []*Feature `json:"features"`
+	AssetType   string     `json:"assetType,omitempty"`
+	AssetLink   string     `json:"assetLink,omitempty"`
+	IsDeleted   bool       `json:"isDeleted,omitempty"`
 }
 
 type Feature struct {
@@ -239,13 +802,16 @@ type Feature struct {
 	Type        string                 `json:"type"`
 	BoundingBox []float64              `json:"bbox,omitempty"`
 	Geometry    *Geometry              `json:"geometry"`
+	Time        *FeatureTime           `json:"time,omitempty"`
 	Properties  map[string]interface{} `json:"properties"`
+	Links       []*Link                `json:"links,omitempty"`
 	AssetType   string                 `json:"assetType,omitempty"`
 	AssetLink   string                 `json:"assetLink,omitempty"`
 	IsDeleted   bool                   `json:"isDeleted"`
-}
 
-type Geometry struct {
-	Type        string        `json:"type"`
-	Coordinates []interface{} `json:"coordinates"`
+	// start and end of the time of the feature, a zero time is an open end
+	start time.Time
+	end   time.Time
+	// recorded is when the entity was stored in the UDA endpoint
+	recorded time.Time
 }
@@ -0,0 +1,364 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// fakeDatahub serves the changes of datasets as a UDA endpoint does, with the index of the next
+// entity as continuation token. Each entity is given as the JSON of its props, or as a JSON
+// object starting with its props and also holding its refs or deleted flag. Entities are given
+// the id ex:<index>, unless the object starts with an id.
+type fakeDatahub struct {
+	datasets map[string][]string
+	status   int
+	// block, when set, holds every request until it is closed
+	block    chan struct{}
+	requests int32
+}
+
+// serveDatahub points the remote datahub at the fake until the test ends
+func serveDatahub(t *testing.T, hub *fakeDatahub) {
+	server := httptest.NewServer(hub)
+	previous := RemoteDatahub
+	RemoteDatahub = &Datahub{Url: server.URL}
+	t.Cleanup(func() {
+		server.Close()
+		RemoteDatahub = previous
+	})
+}
+
+func (hub *fakeDatahub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	atomic.AddInt32(&hub.requests, 1)
+	if hub.block != nil {
+		<-hub.block
+	}
+	if hub.status != 0 {
+		w.WriteHeader(hub.status)
+		return
+	}
+	name := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/datasets/"), "/changes")
+	entities, found := hub.datasets[name]
+	if !found {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	since, _ := strconv.Atoi(r.URL.Query().Get("since"))
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	end := since + limit
+	if end > len(entities) {
+		end = len(entities)
+	}
+
+	var b strings.Builder
+	b.WriteString(`[{"id":"@context","namespaces":{"ex":"http://data.example.org/","_":"http://data.example.org/"}}`)
+	for i := since; i < end; i++ {
+		if strings.HasPrefix(entities[i], `{"id":`) {
+			fmt.Fprintf(&b, `,{"recorded":%d,%s`, i+1, entities[i][1:])
+		} else if strings.HasPrefix(entities[i], `{"props":`) {
+			fmt.Fprintf(&b, `,{"id":"ex:%d","recorded":%d,%s`, i, i+1, entities[i][1:])
+		} else {
+			fmt.Fprintf(&b, `,{"id":"ex:%d","recorded":%d,"props":%s,"refs":{}}`, i, i+1, entities[i])
+		}
+	}
+	fmt.Fprintf(&b, `,{"id":"@continuation","token":"%d"}]`, end)
+	_, _ = w.Write([]byte(b.String()))
+}
+
+// testEntity is an entity with the given id local to data.example.org and properties
+func testEntity(id string, properties map[string]any) *Entity {
+	return &Entity{ID: "http://data.example.org/" + id, Properties: properties, References: map[string]any{}}
+}
+
+// testFeature makes the feature of an entity as a page holding only that entity does
+func testFeature(ds *Dataset, e *Entity) *Feature {
+	ec := NewEntityCollection()
+	ec.Entities = append(ec.Entities, e)
+	return makeFeature(e, ds, newFeatureBatch(ec, ds, "http://localhost"))
+}
+
+// waitForEntityCache waits until the load of the cache started in the background is done
+func waitForEntityCache(t *testing.T, cache *entityCache) {
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		cache.mu.RLock()
+		loaded := !cache.refreshed.IsZero() && !cache.loading
+		cache.mu.RUnlock()
+		if loaded {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the cache is not loaded")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestResolveMembers(t *testing.T) {
+	hub := &fakeDatahub{datasets: map[string][]string{
+		"collection members": {
+			`{"http://data.mimiro.io/models/flatgeo/wkt":"POINT (1 60)"}`,
+			`{"http://data.mimiro.io/models/flatgeo/wkt":"POINT (2 60)"}`,
+			`{"props":{},"refs":{},"deleted":true}`,
+		},
+	}}
+	serveDatahub(t, hub)
+	ds := &Dataset{Name: "collections", Type: "featurecollections", RemoteDataset: "collection members"}
+
+	// the page holds one member along with the collection, the other members are outside it
+	page := NewEntityCollection()
+	page.Entities = append(page.Entities,
+		&Entity{
+			ID:         "http://data.example.org/c",
+			Properties: map[string]any{},
+			References: map[string]any{
+				rdfTypePredicate: flatgeoFeatureCollection,
+				flatgeoFeaturesPredicate: []string{
+					"http://data.example.org/in-page",
+					"http://data.example.org/0",
+					"http://data.example.org/1",
+					"http://data.example.org/2",
+					"http://data.example.org/missing",
+				},
+			},
+		},
+		&Entity{
+			ID:         "http://data.example.org/in-page",
+			Properties: map[string]any{flatgeoWktPredicate: "POINT (0 60)"},
+			References: map[string]any{},
+		},
+	)
+	memberIds := func() []string {
+		collections, _ := convertToFeatureCollections(page, ds, "")
+		ids := make([]string, 0)
+		for _, f := range collections[1].(*FeatureCollection).Features {
+			ids = append(ids, f.Id)
+		}
+		return ids
+	}
+
+	// while the cache loads the collection is published with the members in the page
+	if ids := memberIds(); len(ids) != 1 || ids[0] != "http://data.example.org/in-page" {
+		t.Errorf("got members %v while loading", ids)
+	}
+	waitForEntityCache(t, lookupEntityCache(ds.RemoteDataset))
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	// deleted and missing members are left out
+	expected := []string{"http://data.example.org/in-page", "http://data.example.org/0", "http://data.example.org/1"}
+	ids := memberIds()
+	if len(ids) != len(expected) {
+		t.Fatalf("got members %v, expected %v", ids, expected)
+	}
+	for i := range expected {
+		if ids[i] != expected[i] {
+			t.Errorf("got members %v, expected %v", ids, expected)
+			break
+		}
+	}
+
+	// the missing member is not looked for again on every page
+	requests := atomic.LoadInt32(&hub.requests)
+	memberIds()
+	if atomic.LoadInt32(&hub.requests) != requests {
+		t.Errorf("a missing member should not refresh the cache again")
+	}
+
+	// members not found are logged at most once a minute, whatever their ids
+	if warnings := strings.Count(buf.String(), "of a feature collection is not found"); warnings != 1 {
+		t.Errorf("got %d warnings about missing members: %s", warnings, buf.String())
+	}
+}
+
+// waitForSample waits until the sample read in the background is read or failed
+func waitForSample(t *testing.T, sample *datasetSample) {
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		sample.mu.Lock()
+		finished := !sample.running && (sample.done || !sample.failed.IsZero())
+		sample.mu.Unlock()
+		if finished {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the sample is not read")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSampleDimensions(t *testing.T) {
+	hub := &fakeDatahub{datasets: map[string][]string{
+		"flat":   {`{"http://data.mimiro.io/models/flatgeo/wkt":"POINT (1 2)"}`},
+		"depths": {`{"http://data.mimiro.io/models/flatgeo/wkt":"POINT (1 2)"}`, `{"http://data.mimiro.io/models/flatgeo/wkt":"POINT Z (1 2 3)"}`},
+	}}
+	serveDatahub(t, hub)
+
+	flat := &Dataset{Name: "flat", Type: "features", RemoteDataset: "flat"}
+	flat.dimensions()
+	waitForSample(t, &flat.dimensionsSample)
+	if d := flat.dimensions(); d != 2 {
+		t.Errorf("flat: got %d dimensions", d)
+	}
+	depths := &Dataset{Name: "depths", Type: "features", RemoteDataset: "depths"}
+	depths.dimensions()
+	waitForSample(t, &depths.dimensionsSample)
+	if d := depths.dimensions(); d != 3 {
+		t.Errorf("depths: got %d dimensions", d)
+	}
+	if requests := atomic.LoadInt32(&hub.requests); requests != 2 {
+		t.Errorf("the dimensions should only be sampled once per dataset, got %d requests", requests)
+	}
+}
+
+func TestSampleDimensionsFailure(t *testing.T) {
+	hub := &fakeDatahub{status: http.StatusServiceUnavailable}
+	serveDatahub(t, hub)
+
+	ds := &Dataset{Name: "down", Type: "features", RemoteDataset: "down"}
+	ds.dimensions()
+	waitForSample(t, &ds.dimensionsSample)
+	if d := ds.dimensions(); d != 2 {
+		t.Errorf("got %d dimensions while the endpoint is down", d)
+	}
+	if requests := atomic.LoadInt32(&hub.requests); requests != 1 {
+		t.Errorf("a failed sample should not be tried again right away, got %d requests", requests)
+	}
+	if ds.dimensionsSample.done {
+		t.Errorf("a failed sample should be tried again later")
+	}
+
+	// after the retry interval the sample is read again
+	ds.dimensionsSample.mu.Lock()
+	ds.dimensionsSample.failed = time.Now().Add(-sampleRetryInterval)
+	ds.dimensionsSample.mu.Unlock()
+	ds.dimensions()
+	waitForSample(t, &ds.dimensionsSample)
+	if requests := atomic.LoadInt32(&hub.requests); requests != 2 {
+		t.Errorf("a failed sample should be tried again after the retry interval, got %d requests", requests)
+	}
+}
+
+func TestGetDatasetsWhileSampling(t *testing.T) {
+	// the UDA endpoint does not answer until the test ends
+	hub := &fakeDatahub{block: make(chan struct{})}
+	serveDatahub(t, hub)
+	t.Cleanup(func() { close(hub.block) })
+	RemoteDatahub.Datasets = []*Dataset{
+		{Name: "a", Type: "features", RemoteDataset: "a"},
+		{Name: "b", Type: "features", RemoteDataset: "b"},
+	}
+
+	for i := 0; i < 2; i++ {
+		done := make(chan *httptest.ResponseRecorder)
+		go func() {
+			rec := httptest.NewRecorder()
+			_ = getDatasets(echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/datasets", nil), rec))
+			done <- rec
+		}()
+		select {
+		case rec := <-done:
+			// the datasets are taken to be flat until they are sampled
+			if rec.Code != http.StatusOK || strings.Count(rec.Body.String(), `"dimensions":2`) != 2 {
+				t.Errorf("got %d %s", rec.Code, rec.Body.String())
+			}
+		case <-time.After(time.Second):
+			t.Fatal("listing the datasets waits for the UDA endpoint")
+		}
+	}
+}
+
+func TestGetHealth(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/health", nil)
+	rec := httptest.NewRecorder()
+	if err := getHealth(echo.New().NewContext(req, rec)); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusOK || rec.Body.String() != "Running" {
+		t.Errorf("got %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestGetFeature(t *testing.T) {
+	hub := &fakeDatahub{datasets: map[string][]string{
+		"lookups": {
+			`{"http://data.mimiro.io/models/flatgeo/wkt":"POINT (10 60)"}`,
+			`{"id":"http://data.example.org/stations/7","props":{},"refs":{}}`,
+			`{"id":"http://data.example.org/buoys/7","props":{},"refs":{}}`,
+			`{"id":"ex:gone","props":{},"refs":{}}`,
+			`{"id":"ex:gone","props":{},"refs":{},"deleted":true}`,
+		},
+	}}
+	serveDatahub(t, hub)
+	RemoteDatahub.Datasets = []*Dataset{
+		{Name: "lookups", Type: "features", RemoteDataset: "lookups", IdNamespace: "http://data.example.org/"},
+	}
+	get := func(id string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(req, rec)
+		c.SetParamNames("dataset", "id")
+		c.SetParamValues("lookups", url.PathEscape(id))
+		if err := getFeature(c); err != nil {
+			t.Fatal(err)
+		}
+		return rec
+	}
+
+	// the first lookup starts the load of the entities and asks to come back
+	rec := get("0")
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("got %d with Retry-After %q while loading", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	waitForEntityCache(t, lookupEntityCache("lookups"))
+
+	tests := []struct {
+		id     string
+		status int
+		found  string
+	}{
+		{id: "http://data.example.org/0", status: http.StatusOK, found: "http://data.example.org/0"},
+		{id: "ex:0", status: http.StatusOK, found: "http://data.example.org/0"},
+		{id: "0", status: http.StatusOK, found: "http://data.example.org/0"},
+		{id: "stations/7", status: http.StatusOK, found: "http://data.example.org/stations/7"},
+		{id: "buoys/7", status: http.StatusOK, found: "http://data.example.org/buoys/7"},
+		// a local name of several entities finds none of them
+		{id: "7", status: http.StatusNotFound},
+		{id: "gone", status: http.StatusNotFound},
+		{id: "ex:gone", status: http.StatusNotFound},
+		{id: "missing", status: http.StatusNotFound},
+	}
+	for _, test := range tests {
+		rec := get(test.id)
+		if rec.Code != test.status {
+			t.Errorf("%s: got %d, expected %d", test.id, rec.Code, test.status)
+			continue
+		}
+		if test.found != "" && !strings.Contains(rec.Body.String(), `"id":"`+test.found+`"`) {
+			t.Errorf("%s: got %s, expected %s", test.id, rec.Body.String(), test.found)
+		}
+	}
+
+	// an id that is not found is looked for once and then not again for a while
+	requests := atomic.LoadInt32(&hub.requests)
+	for i := 0; i < 3; i++ {
+		if rec := get("unknown"); rec.Code != http.StatusNotFound {
+			t.Errorf("got %d for an unknown id", rec.Code)
+		}
+	}
+	if extra := atomic.LoadInt32(&hub.requests) - requests; extra != 1 {
+		t.Errorf("an unknown id should cause one refresh, got %d requests", extra)
+	}
+}
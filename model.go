@@ -5,6 +5,8 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
+	"strconv"
 	"strings"
 )
 
@@ -41,6 +43,18 @@ func (anEntity *Entity) getReferenceValue(typeURI string) (string, error) {
 	return "", errors.New("no reference for type")
 }
 
+func (anEntity *Entity) getReferenceValues(typeURI string) []string {
+	if values, found := anEntity.References[typeURI]; found {
+		switch v := values.(type) {
+		case []string:
+			return v
+		case string:
+			return []string{v}
+		}
+	}
+	return []string{}
+}
+
 func (anEntity *Entity) getStringLiteralPropertyValue(typeURI string) (string, error) {
 	if values, found := anEntity.Properties[typeURI]; found {
 		switch v := values.(type) {
@@ -83,6 +97,88 @@ func (anEntity *Entity) getIntLiteralPropertyValue(typeURI string) (int, error)
 	return 0, errors.New("no property int32 literal")
 }
 
+// findPropertyValue looks up a property by its full URI, or by its local name when no property
+// has that URI. When several properties share the local name the one with the lowest URI wins.
+func (anEntity *Entity) findPropertyValue(name string) (any, bool) {
+	if value, found := anEntity.Properties[name]; found {
+		return value, true
+	}
+	match := ""
+	for k := range anEntity.Properties {
+		if stripUrl(k) == name && (match == "" || k < match) {
+			match = k
+		}
+	}
+	if match == "" {
+		return nil, false
+	}
+	return anEntity.Properties[match], true
+}
+
+// getNumberPropertyValue returns the property, found by URI or local name, as a number. Numeric
+// strings are parsed, accepting a decimal comma, and single valued lists are unwrapped.
+func (anEntity *Entity) getNumberPropertyValue(name string) (float64, error) {
+	value, found := anEntity.findPropertyValue(name)
+	if !found {
+		return 0, errors.New("no property " + name)
+	}
+	return toNumber(value)
+}
+
+// toNumber converts a number, a numeric string or a list of one of them into a finite number.
+// A string may use a decimal comma instead of a point, but not digit grouping: "1,5" is 1.5,
+// while "1,234" could be either and is refused, as are "1,234,567" and "1,234.5".
+func toNumber(value any) (float64, error) {
+	var f float64
+	switch v := value.(type) {
+	case float64:
+		f = v
+	case int64:
+		f = float64(v)
+	case string:
+		s := strings.TrimSpace(v)
+		if commas := strings.Count(s, ","); commas > 0 {
+			decimals := s[strings.Index(s, ",")+1:]
+			if commas > 1 || strings.Contains(s, ".") || len(decimals) == 3 {
+				return 0, fmt.Errorf("not a number, or a number with digit grouping: %s", v)
+			}
+			s = strings.Replace(s, ",", ".", 1)
+		}
+		var err error
+		if f, err = strconv.ParseFloat(s, 64); err != nil {
+			return 0, fmt.Errorf("not a number: %s", v)
+		}
+	case []any:
+		if len(v) == 1 {
+			return toNumber(v[0])
+		}
+		return 0, fmt.Errorf("not a number: %v", value)
+	default:
+		return 0, fmt.Errorf("not a number: %v", value)
+	}
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, fmt.Errorf("not a finite number: %v", value)
+	}
+	return f, nil
+}
+
+func (anEntity *Entity) getFloatListPropertyValue(typeURI string) ([]float64, error) {
+	if values, found := anEntity.Properties[typeURI]; found {
+		if list, ok := values.([]any); ok {
+			result := make([]float64, 0, len(list))
+			for _, v := range list {
+				f, ok := v.(float64)
+				if !ok {
+					return nil, errors.New("property list contains non numeric value")
+				}
+				result = append(result, f)
+			}
+			return result, nil
+		}
+	}
+	return nil, errors.New("no property float list literal")
+}
+
 // -------------  Context ------------- //
 
 func NewContext() *Context {
@@ -133,6 +229,20 @@ func (aContext *Context) GetFullURIFromCURIE(curie string) (string, error) {
 	}
 }
 
+// GetCURIE shortens the uri with the prefix of the longest matching namespace expansion
+func (aContext *Context) GetCURIE(uri string) (string, error) {
+	expansion := ""
+	for e := range aContext.expansionToPrefixMappings {
+		if strings.HasPrefix(uri, e) && len(e) > len(expansion) {
+			expansion = e
+		}
+	}
+	if expansion == "" {
+		return "", errors.New("no prefix for uri: " + uri)
+	}
+	return aContext.expansionToPrefixMappings[expansion] + ":" + uri[len(expansion):], nil
+}
+
 // Merge joins the other context to the man context
 func (aContext *Context) Merge(other *Context) error {
 	for k, m := range other.expansionToPrefixMappings {
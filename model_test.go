@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestToNumber(t *testing.T) {
+	tests := []struct {
+		value  any
+		number float64
+		err    bool
+	}{
+		{value: 60.5, number: 60.5},
+		{value: int64(60), number: 60},
+		{value: "60.5", number: 60.5},
+		{value: " -60.5 ", number: -60.5},
+		{value: "1e3", number: 1000},
+		{value: "60,5", number: 60.5},
+		{value: "60,12345", number: 60.12345},
+		{value: []any{"34,79"}, number: 34.79},
+		// digit grouping is refused rather than read as a decimal comma
+		{value: "1,234", err: true},
+		{value: "1,234,567", err: true},
+		{value: "1,234.5", err: true},
+		// values that are not finite cannot be published as GeoJSON
+		{value: "NaN", err: true},
+		{value: "Inf", err: true},
+		{value: "+Infinity", err: true},
+		{value: "-inf", err: true},
+		{value: "1e999", err: true},
+		{value: "", err: true},
+		{value: "north", err: true},
+		{value: true, err: true},
+		{value: []any{1.0, 2.0}, err: true},
+		{value: []any{}, err: true},
+	}
+	for _, test := range tests {
+		number, err := toNumber(test.value)
+		if test.err != (err != nil) || !test.err && number != test.number {
+			t.Errorf("%#v: got %v, %v", test.value, number, err)
+		}
+	}
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// The OGC API - Features Part 1 Core endpoints. Each dataset is published as a collection whose
+// items are the current features of the dataset, without deleted features. The items of a
+// featurecollections dataset are the member features of its collections. Single items are
+// looked up by id in a cache of the dataset.
+
+const (
+	conformanceCore    = "http://www.opengis.net/spec/ogcapi-features-1/1.0/conf/core"
+	conformanceGeoJSON = "http://www.opengis.net/spec/ogcapi-features-1/1.0/conf/geojson"
+	conformanceOAS30   = "http://www.opengis.net/spec/ogcapi-features-1/1.0/conf/oas30"
+	// the filter and queryables conformance classes of Part 3 and the CQL2 classes of the
+	// supported operators
+	conformanceFilter         = "http://www.opengis.net/spec/ogcapi-features-3/1.0/conf/filter"
+	conformanceFeaturesFilter = "http://www.opengis.net/spec/ogcapi-features-3/1.0/conf/features-filter"
+	conformanceQueryables     = "http://www.opengis.net/spec/ogcapi-features-3/1.0/conf/queryables"
+	conformanceQueryParams    = "http://www.opengis.net/spec/ogcapi-features-3/1.0/conf/queryables-query-parameters"
+	conformanceCQL2Basic      = "http://www.opengis.net/spec/cql2/1.0/conf/basic-cql2"
+	conformanceCQL2Text       = "http://www.opengis.net/spec/cql2/1.0/conf/cql2-text"
+	conformanceCQL2JSON       = "http://www.opengis.net/spec/cql2/1.0/conf/cql2-json"
+	conformanceCQL2Advanced   = "http://www.opengis.net/spec/cql2/1.0/conf/advanced-comparison-operators"
+	conformanceCQL2Spatial    = "http://www.opengis.net/spec/cql2/1.0/conf/basic-spatial-functions"
+	conformanceCQL2Temporal   = "http://www.opengis.net/spec/cql2/1.0/conf/temporal-functions"
+
+	mediaTypeJSON    = "application/json"
+	mediaTypeGeoJSON = "application/geo+json"
+)
+
+type LandingPage struct {
+	Title       string  `json:"title"`
+	Description string  `json:"description"`
+	Links       []*Link `json:"links"`
+}
+
+type Conformance struct {
+	ConformsTo []string `json:"conformsTo"`
+}
+
+type Collections struct {
+	Links       []*Link       `json:"links"`
+	Collections []*Collection `json:"collections"`
+}
+
+type Collection struct {
+	Id          string   `json:"id"`
+	Title       string   `json:"title"`
+	Description string   `json:"description,omitempty"`
+	Links       []*Link  `json:"links"`
+	ItemType    string   `json:"itemType"`
+	Crs         []string `json:"crs"`
+}
+
+type FeatureCollectionResponse struct {
+	Type           string     `json:"type"`
+	Features       []*Feature `json:"features"`
+	Links          []*Link    `json:"links"`
+	TimeStamp      string     `json:"timeStamp"`
+	NumberMatched  *int       `json:"numberMatched,omitempty"`
+	NumberReturned int        `json:"numberReturned"`
+}
+
+func getLandingPage(c echo.Context) error {
+	baseUrl := requestBaseUrl(c)
+	return c.JSON(http.StatusOK, &LandingPage{
+		Title:       "OGC UDA Data Publisher",
+		Description: "Features of the datasets of a UDA endpoint",
+		Links: []*Link{
+			{Href: baseUrl + "/", Rel: "self", Type: mediaTypeJSON, Title: "This document"},
+			{Href: baseUrl + "/api", Rel: "service-desc", Type: mediaTypeOpenAPI, Title: "The OpenAPI definition of the service"},
+			{Href: baseUrl + "/api.html", Rel: "service-doc", Type: mediaTypeHTML, Title: "The documentation of the service"},
+			{Href: baseUrl + "/conformance", Rel: "conformance", Type: mediaTypeJSON, Title: "Conformance classes implemented by this service"},
+			{Href: baseUrl + "/collections", Rel: "data", Type: mediaTypeJSON, Title: "The collections of features"},
+		},
+	})
+}
+
+func getConformance(c echo.Context) error {
+	return c.JSON(http.StatusOK, &Conformance{ConformsTo: []string{
+		conformanceCore, conformanceGeoJSON, conformanceOAS30,
+		conformanceFilter, conformanceFeaturesFilter, conformanceQueryables, conformanceQueryParams,
+		conformanceCQL2Basic, conformanceCQL2Text, conformanceCQL2JSON,
+		conformanceCQL2Advanced, conformanceCQL2Spatial, conformanceCQL2Temporal,
+	}})
+}
+
+func getCollections(c echo.Context) error {
+	baseUrl := requestBaseUrl(c)
+	collections := &Collections{
+		Links:       []*Link{{Href: baseUrl + "/collections", Rel: "self", Type: mediaTypeJSON, Title: "This document"}},
+		Collections: make([]*Collection, 0, len(RemoteDatahub.Datasets)),
+	}
+	for _, ds := range RemoteDatahub.Datasets {
+		collections.Collections = append(collections.Collections, makeCollection(ds, baseUrl))
+	}
+	return c.JSON(http.StatusOK, collections)
+}
+
+func getCollection(c echo.Context) error {
+	ds := lookupDataset(c.Param("collection"))
+	if ds == nil {
+		return c.NoContent(http.StatusNotFound)
+	}
+	return c.JSON(http.StatusOK, makeCollection(ds, requestBaseUrl(c)))
+}
+
+func makeCollection(ds *Dataset, baseUrl string) *Collection {
+	collectionUrl := baseUrl + "/collections/" + url.PathEscape(ds.Name)
+	title := ds.Title
+	if title == "" {
+		title = ds.Name
+	}
+	links := []*Link{
+		{Href: collectionUrl, Rel: "self", Type: mediaTypeJSON, Title: "This collection"},
+		{Href: collectionUrl + "/items", Rel: "items", Type: mediaTypeGeoJSON, Title: "The features of " + title},
+	}
+	return &Collection{
+		Id:          ds.Name,
+		Title:       title,
+		Description: ds.Description,
+		Links:       append(links, schemaLinks(collectionUrl)...),
+		ItemType:    "feature",
+		Crs:         []string{crs84URI},
+	}
+}
+
+func getItems(c echo.Context) error {
+	ds := lookupDataset(c.Param("collection"))
+	if ds == nil {
+		return c.NoContent(http.StatusNotFound)
+	}
+
+	limit, err := parseLimit(c.QueryParam("limit"), defaultItemsLimit, maxItemsLimit)
+	if err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+	offset, err := parseOffset(c.QueryParam("offset"))
+	if err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+	cursor, err := decodeItemsCursor(c.QueryParam("cursor"))
+	if err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+	filters, err := parseFeatureFilters(c, ds, itemsParameters)
+	if err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+
+	baseUrl := requestBaseUrl(c)
+	page, err := readItems(ds, baseUrl, cursor, limit, offset, filters)
+	if err != nil {
+		return upstreamErrorResponse(c, err)
+	}
+
+	itemsUrl := baseUrl + "/collections/" + url.PathEscape(ds.Name) + "/items"
+	response := &FeatureCollectionResponse{
+		Type:           "FeatureCollection",
+		Features:       page.Features,
+		Links:          []*Link{{Href: baseUrl + c.Request().URL.RequestURI(), Rel: "self", Type: mediaTypeGeoJSON, Title: "This document"}},
+		TimeStamp:      time.Now().UTC().Format(time.RFC3339),
+		NumberReturned: len(page.Features),
+	}
+	if page.Next != nil {
+		response.Links = append(response.Links, &Link{
+			Href:  pageUrl(itemsUrl, c.QueryParams(), page.Next, limit),
+			Rel:   "next",
+			Type:  mediaTypeGeoJSON,
+			Title: "Next page",
+		})
+	} else {
+		response.NumberMatched = &page.NumberMatched
+	}
+	return geoJSON(c, http.StatusOK, response)
+}
+
+func getItem(c echo.Context) error {
+	ds := lookupDataset(c.Param("collection"))
+	if ds == nil {
+		return c.NoContent(http.StatusNotFound)
+	}
+	id, err := url.PathUnescape(c.Param("featureId"))
+	if err != nil {
+		return c.NoContent(http.StatusNotFound)
+	}
+
+	baseUrl := requestBaseUrl(c)
+	f, err := readFeature(ds, id, baseUrl)
+	if err != nil {
+		return upstreamErrorResponse(c, err)
+	}
+	if f == nil {
+		return c.NoContent(http.StatusNotFound)
+	}
+
+	collectionUrl := baseUrl + "/collections/" + url.PathEscape(ds.Name)
+	f.Links = append([]*Link{
+		{Href: baseUrl + c.Request().URL.EscapedPath(), Rel: "self", Type: mediaTypeGeoJSON, Title: "This document"},
+		{Href: collectionUrl, Rel: "collection", Type: mediaTypeJSON, Title: "The collection of the feature"},
+	}, f.Links...)
+	return geoJSON(c, http.StatusOK, f)
+}
+
+// makeItems converts a page of entities to the features of the collection, leaving out deleted
+// features and flattening feature collections into their members
+func makeItems(ec *EntityCollection, ds *Dataset, baseUrl string) ([]*Feature, error) {
+	var converted []any
+	switch ds.Type {
+	case "features":
+		converted, _ = convertToFeatures(ec, ds, baseUrl)
+	case "featurecollections":
+		converted, _ = convertToFeatureCollections(ec, ds, baseUrl)
+	default:
+		return nil, errors.New("unknown dataset type " + ds.Type)
+	}
+
+	features := make([]*Feature, 0)
+	seen := make(map[string]bool)
+	add := func(f *Feature) {
+		if !f.IsDeleted && !seen[f.Id] {
+			seen[f.Id] = true
+			features = append(features, f)
+		}
+	}
+	for _, item := range converted {
+		switch v := item.(type) {
+		case *Feature:
+			add(v)
+		case *FeatureCollection:
+			if !v.IsDeleted {
+				for _, f := range v.Features {
+					add(f)
+				}
+			}
+		}
+	}
+	return features, nil
+}
+
+// geoJSON sends the response with the GeoJSON media type
+func geoJSON(c echo.Context, code int, response any) error {
+	return jsonResponse(c, code, mediaTypeGeoJSON, response)
+}
+
+// jsonResponse sends the response as JSON with a media type other than application/json
+func jsonResponse(c echo.Context, code int, mediaType string, response any) error {
+	body, err := json.Marshal(response)
+	if err != nil {
+		return err
+	}
+	return c.Blob(code, mediaType, body)
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// The OpenAPI 3.0 definition of the service, generated from the datasets of the configuration,
+// with the paths of each collection and dataset and the schemas of their features, and an HTML
+// view of it rendered by Swagger UI.
+
+const (
+	mediaTypeOpenAPI = "application/vnd.oai.openapi+json;version=3.0"
+	mediaTypeHTML    = "text/html"
+
+	// swaggerUIUrl is where the HTML view loads Swagger UI from, pinned to one release so that
+	// the page does not change with new releases
+	swaggerUIUrl = "https://unpkg.com/swagger-ui-dist@5.17.14"
+)
+
+// getAPI serves the definition, or its HTML view to browsers
+func getAPI(c echo.Context) error {
+	if isHTMLRequest(c) {
+		return getAPIDocumentation(c)
+	}
+	return jsonResponse(c, http.StatusOK, mediaTypeOpenAPI, makeOpenAPI(requestBaseUrl(c)))
+}
+
+func getAPIDocumentation(c echo.Context) error {
+	return c.HTML(http.StatusOK, `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>OGC UDA Data Publisher API</title>
+  <link rel="stylesheet" href="`+swaggerUIUrl+`/swagger-ui.css" crossorigin="anonymous" referrerpolicy="no-referrer">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="`+swaggerUIUrl+`/swagger-ui-bundle.js" crossorigin="anonymous" referrerpolicy="no-referrer"></script>
+  <script>
+    SwaggerUIBundle({ url: "api", dom_id: "#swagger-ui" });
+  </script>
+</body>
+</html>`)
+}
+
+// makeOpenAPI builds the definition. The properties of features are described by the property
+// mapping of each dataset, as sampling every dataset on each request would be too costly.
+func makeOpenAPI(baseUrl string) map[string]any {
+	paths := map[string]any{
+		"/": operation("getLandingPage", "The landing page", "Server", nil,
+			jsonContent(schemaRef("LandingPage"))),
+		"/conformance": operation("getConformance", "The conformance classes implemented", "Server", nil,
+			jsonContent(schemaRef("Conformance"))),
+		"/api": operation("getAPI", "This definition, or its HTML view with f=html or when HTML is accepted", "Server", nil,
+			map[string]any{
+				mediaTypeOpenAPI: map[string]any{"schema": map[string]any{"type": "object"}},
+				mediaTypeHTML:    map[string]any{"schema": map[string]any{"type": "string"}},
+			}),
+		"/api.html": operation("getAPIDocumentation", "An HTML view of this definition", "Server", nil,
+			map[string]any{mediaTypeHTML: map[string]any{"schema": map[string]any{"type": "string"}}}),
+		"/collections": operation("getCollections", "The collections of features", "Capabilities", nil,
+			jsonContent(schemaRef("Collections"))),
+		"/datasets": operation("getDatasets", "The datasets and their configuration", "Datasets", nil,
+			jsonContent(map[string]any{"type": "array", "items": schemaRef("Dataset")})),
+	}
+	schemas := openAPISchemas()
+
+	for _, ds := range RemoteDatahub.Datasets {
+		name := url.PathEscape(ds.Name)
+		id := schemaName(ds.Name)
+		title := ds.Title
+		if title == "" {
+			title = ds.Name
+		}
+
+		schemas[id+".properties"] = datasetPropertiesSchema(ds)
+		schemas[id+".Feature"] = map[string]any{"allOf": []any{
+			schemaRef("Feature"),
+			map[string]any{"type": "object", "properties": map[string]any{"properties": schemaRef(id + ".properties")}},
+		}}
+		schemas[id+".FeatureCollection"] = map[string]any{"allOf": []any{
+			schemaRef("FeatureCollection"),
+			map[string]any{"type": "object", "properties": map[string]any{
+				"features": map[string]any{"type": "array", "items": schemaRef(id + ".Feature")},
+			}},
+		}}
+
+		filters := propertyFilterParameters(ds)
+		itemsParameterRefs := append(endpointParameterRefs(itemsParameterNames, nil), filters...)
+		changesParameterRefs := append(endpointParameterRefs(changesParameterNames, changesParameterComponents), filters...)
+		changeItem := schemaRef(id + ".Feature")
+		if ds.Type == "featurecollections" {
+			changeItem = schemaRef(id + ".FeatureCollection")
+		}
+
+		paths["/collections/"+name] = operation("describeCollection."+id, "The collection "+title, "Capabilities", nil,
+			jsonContent(schemaRef("Collection")))
+		paths["/collections/"+name+"/items"] = operation("getFeatures."+id, "The features of "+title, "Data", itemsParameterRefs,
+			geoJSONContent(schemaRef(id+".FeatureCollection")))
+		paths["/collections/"+name+"/items/{featureId}"] = operation("getFeature."+id, "One feature of "+title, "Data",
+			parameterRefs("featureId"), geoJSONContent(schemaRef(id+".Feature")))
+		paths["/collections/"+name+"/queryables"] = operation("getQueryables."+id, "The properties of "+title+" that can be filtered on", "Capabilities", nil,
+			map[string]any{mediaTypeSchema: map[string]any{"schema": map[string]any{"type": "object"}}})
+		paths["/collections/"+name+"/schema"] = operation("getSchema."+id, "The schema of the features of "+title, "Capabilities", nil,
+			map[string]any{mediaTypeSchema: map[string]any{"schema": map[string]any{"type": "object"}}})
+		paths["/datasets/"+name] = operation("getDataset."+id, "The configuration of "+title, "Datasets", nil,
+			jsonContent(schemaRef("Dataset")))
+		paths["/datasets/"+name+"/changes"] = operation("getChanges."+id, "The changes of "+title+
+			", preceded by a context object and followed by a continuation object holding the since token of the next page", "Datasets", changesParameterRefs,
+			jsonContent(map[string]any{"type": "array", "items": map[string]any{"oneOf": []any{
+				changeItem,
+				map[string]any{"type": "object", "description": "The context or the continuation", "properties": map[string]any{
+					"id":    map[string]any{"type": "string", "enum": []any{"@context", "@continuation"}},
+					"token": map[string]any{"type": "string"},
+				}},
+			}}}))
+		paths["/datasets/"+name+"/features/{id}"] = operation("getDatasetFeature."+id, "One feature of "+title, "Datasets",
+			parameterRefs("id", "crs"), jsonContent(schemaRef(id+".Feature")))
+	}
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":       "OGC UDA Data Publisher",
+			"description": "Features of the datasets of a UDA endpoint, published as OGC API - Features and as streams of changes",
+			"version":     "1.0.0",
+		},
+		"servers": []any{map[string]any{"url": baseUrl}},
+		"tags": []any{
+			map[string]any{"name": "Server", "description": "The landing page, conformance and API definition"},
+			map[string]any{"name": "Capabilities", "description": "The collections and their schemas"},
+			map[string]any{"name": "Data", "description": "The features of the collections"},
+			map[string]any{"name": "Datasets", "description": "The datasets and their changes"},
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"parameters": openAPIParameters(),
+			"schemas":    schemas,
+		},
+	}
+}
+
+// operation is a path with a GET operation answering with a 200 response of the given content,
+// or the status of the UDA endpoint when it fails
+func operation(operationId string, summary string, tag string, parameters []any, content map[string]any) map[string]any {
+	get := map[string]any{
+		"operationId": operationId,
+		"summary":     summary,
+		"tags":        []any{tag},
+		"responses": map[string]any{
+			"200":     map[string]any{"description": summary, "content": content},
+			"default": map[string]any{"description": "A failure, such as 400 for invalid parameters, 404 for unknown ids or the status of the UDA endpoint"},
+		},
+	}
+	if len(parameters) > 0 {
+		get["parameters"] = parameters
+	}
+	return map[string]any{"get": get}
+}
+
+func jsonContent(schema map[string]any) map[string]any {
+	return map[string]any{mediaTypeJSON: map[string]any{"schema": schema}}
+}
+
+func geoJSONContent(schema map[string]any) map[string]any {
+	return map[string]any{mediaTypeGeoJSON: map[string]any{"schema": schema}}
+}
+
+func schemaRef(name string) map[string]any {
+	return map[string]any{"$ref": "#/components/schemas/" + name}
+}
+
+func parameterRefs(names ...string) []any {
+	refs := make([]any, 0, len(names))
+	for _, name := range names {
+		refs = append(refs, map[string]any{"$ref": "#/components/parameters/" + name})
+	}
+	return refs
+}
+
+// changesParameterComponents are the components of the parameters of changes that are not
+// those of the parameters of items with the same name
+var changesParameterComponents = map[string]string{"limit": "changesLimit"}
+
+// endpointParameterRefs refers to the components of the query parameters of an endpoint, which
+// are named as the parameters unless components gives another name
+func endpointParameterRefs(names []string, components map[string]string) []any {
+	componentNames := make([]string, 0, len(names))
+	for _, name := range names {
+		if component, found := components[name]; found {
+			name = component
+		}
+		componentNames = append(componentNames, name)
+	}
+	return parameterRefs(componentNames...)
+}
+
+var schemaNameReplacer = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// schemaName turns a dataset name into a component name, which may only hold letters, digits,
+// dots, dashes and underscores
+func schemaName(name string) string {
+	return schemaNameReplacer.ReplaceAllString(name, "_")
+}
+
+// datasetPropertiesSchema describes the feature properties of the dataset by its property
+// mapping. Other properties are allowed unless the mapping restricts them.
+func datasetPropertiesSchema(ds *Dataset) map[string]any {
+	properties := make(map[string]any)
+	for _, m := range ds.propertyMapping().Mappings {
+		s := mappedSchema(m)
+		property := map[string]any{}
+		if s.Type != "" {
+			property["type"] = s.Type
+		}
+		if s.Format != "" {
+			property["format"] = s.Format
+		}
+		if s.Title != "" {
+			property["title"] = s.Title
+		}
+		properties[schemaKey(nil, m)] = property
+	}
+	for _, name := range ds.propertyMapping().Include {
+		if ds.propertyMapping().lookup(name) == nil {
+			properties[name] = map[string]any{}
+		}
+	}
+	return map[string]any{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": len(ds.propertyMapping().Include) == 0,
+	}
+}
+
+// propertyFilterParameters are the query parameters filtering on the id and the queryables of
+// the dataset. Datasets without a mapping accept any property, which OpenAPI can not list.
+func propertyFilterParameters(ds *Dataset) []any {
+	parameters := []any{map[string]any{
+		"name":        "id",
+		"in":          "query",
+		"required":    false,
+		"description": "Selects the feature with the id, the full URI of its entity, or the features whose id compares with it by the operator the value starts with: !=, >, >=, < or <=",
+		"schema":      map[string]any{"type": "string"},
+	}}
+	for _, name := range ds.queryables() {
+		if name == "id" {
+			// a property named id is shadowed by the id of the feature
+			continue
+		}
+		parameters = append(parameters, map[string]any{
+			"name":        name,
+			"in":          "query",
+			"required":    false,
+			"description": "Selects the features whose " + name + " property is equal to the value, or compares it with the operator the value starts with: !=, >, >=, < or <=",
+			"schema":      map[string]any{"type": "string"},
+		})
+	}
+	return parameters
+}
+
+func openAPIParameters() map[string]any {
+	query := func(name string, description string, schema map[string]any) map[string]any {
+		return map[string]any{"name": name, "in": "query", "required": false, "description": description, "schema": schema, "style": "form", "explode": false}
+	}
+	path := func(name string, description string) map[string]any {
+		return map[string]any{"name": name, "in": "path", "required": true, "description": description, "schema": map[string]any{"type": "string"}}
+	}
+	// a CRS is not always a URI, as EPSG:n, URNs and bare EPSG codes are accepted too
+	crsSchema := map[string]any{"type": "string"}
+	return map[string]any{
+		"limit": query("limit", "The number of features in a page",
+			map[string]any{"type": "integer", "minimum": 1, "maximum": maxItemsLimit, "default": defaultItemsLimit}),
+		"changesLimit": query("limit", "The number of changes read from the UDA endpoint",
+			map[string]any{"type": "integer", "minimum": 1, "maximum": upstreamPageSize, "default": upstreamPageSize}),
+		"offset": query("offset", "The number of features to skip",
+			map[string]any{"type": "integer", "minimum": 0, "default": 0}),
+		"cursor": query("cursor", "The position of the page, as given in next links",
+			map[string]any{"type": "string"}),
+		"since": query("since", "The continuation token of the previous page of changes",
+			map[string]any{"type": "string"}),
+		"bbox": query("bbox", "Selects the features intersecting the box minx,miny,maxx,maxy or minx,miny,minz,maxx,maxy,maxz",
+			map[string]any{"type": "array", "minItems": 4, "maxItems": 6, "items": map[string]any{"type": "number"}}),
+		"bbox-crs": query("bbox-crs", "The CRS of the bbox, as a URI, a URN or EPSG:n, CRS84 by default", crsSchema),
+		"datetime": query("datetime", "Selects the features whose time overlaps an instant or an interval, with .. as an open end",
+			map[string]any{"type": "string"}),
+		"filter": query("filter", "A CQL2 expression selecting features",
+			map[string]any{"type": "string"}),
+		"filter-lang": query("filter-lang", "The encoding of the filter",
+			map[string]any{"type": "string", "enum": []any{filterLangText, filterLangJSON}, "default": filterLangText}),
+		"filter-crs": query("filter-crs", "The CRS of the geometries in the filter, as a URI, a URN or EPSG:n, CRS84 by default", crsSchema),
+		"crs":        query("crs", "The CRS of the response, as a URI, a URN or EPSG:n, CRS84 by default", crsSchema),
+		"f": query("f", "The format of the response. Only JSON is served, so the parameter is accepted and ignored",
+			map[string]any{"type": "string"}),
+		"featureId": path("featureId", "The id of the feature: its URI, a CURIE, the id without the namespace of the dataset, or a unique local name"),
+		"id":        path("id", "The id of the feature: its URI, a CURIE, the id without the namespace of the dataset, or a unique local name"),
+	}
+}
+
+func openAPISchemas() map[string]any {
+	object := func(properties map[string]any, required ...string) map[string]any {
+		s := map[string]any{"type": "object", "properties": properties}
+		if len(required) > 0 {
+			r := make([]any, 0, len(required))
+			for _, name := range required {
+				r = append(r, name)
+			}
+			s["required"] = r
+		}
+		return s
+	}
+	str := map[string]any{"type": "string"}
+	array := func(items map[string]any) map[string]any {
+		return map[string]any{"type": "array", "items": items}
+	}
+	links := array(schemaRef("Link"))
+	bbox := map[string]any{"type": "array", "minItems": 4, "maxItems": 6, "items": map[string]any{"type": "number"}}
+
+	geometryTypes := make([]any, 0)
+	for _, t := range []string{"Point", "MultiPoint", "LineString", "MultiLineString", "Polygon", "MultiPolygon", "GeometryCollection"} {
+		geometryTypes = append(geometryTypes, t)
+	}
+
+	return map[string]any{
+		"Link":        object(map[string]any{"href": str, "rel": str, "type": str, "title": str}, "href", "rel"),
+		"LandingPage": object(map[string]any{"title": str, "description": str, "links": links}, "links"),
+		"Conformance": object(map[string]any{"conformsTo": array(str)}, "conformsTo"),
+		"Collection": object(map[string]any{
+			"id": str, "title": str, "description": str, "links": links, "itemType": str, "crs": array(str),
+		}, "id", "links"),
+		"Collections": object(map[string]any{"links": links, "collections": array(schemaRef("Collection"))}, "links", "collections"),
+		"Geometry": object(map[string]any{
+			"type":        map[string]any{"type": "string", "enum": geometryTypes},
+			"coordinates": map[string]any{"type": "array", "items": map[string]any{}},
+			"geometries":  array(schemaRef("Geometry")),
+		}, "type"),
+		"Feature": object(map[string]any{
+			"type":       map[string]any{"type": "string", "enum": []any{"Feature"}},
+			"id":         str,
+			"bbox":       bbox,
+			"geometry":   map[string]any{"allOf": []any{schemaRef("Geometry")}, "nullable": true},
+			"time":       map[string]any{"type": "object", "description": "The time of the feature, a date, timestamp or interval", "nullable": true},
+			"properties": map[string]any{"type": "object"},
+			"links":      links,
+			"assetType":  str,
+			"assetLink":  str,
+			"isDeleted":  map[string]any{"type": "boolean"},
+		}, "type", "id", "geometry", "properties"),
+		"FeatureCollection": object(map[string]any{
+			"type":           map[string]any{"type": "string", "enum": []any{"FeatureCollection"}},
+			"id":             str,
+			"bbox":           bbox,
+			"features":       array(schemaRef("Feature")),
+			"links":          links,
+			"timeStamp":      map[string]any{"type": "string", "format": "date-time"},
+			"numberMatched":  map[string]any{"type": "integer", "minimum": 0},
+			"numberReturned": map[string]any{"type": "integer", "minimum": 0},
+			"assetType":      str,
+			"assetLink":      str,
+			"isDeleted":      map[string]any{"type": "boolean"},
+		}, "type", "features"),
+		"Dataset": object(map[string]any{
+			"name":        str,
+			"type":        map[string]any{"type": "string", "enum": []any{"features", "featurecollections"}},
+			"title":       str,
+			"description": str,
+			"remoteName":  str,
+		}, "name", "type", "remoteName"),
+	}
+}
+
+// isHTMLRequest is true when a browser asks for the page rather than a client for the document
+func isHTMLRequest(c echo.Context) bool {
+	return c.QueryParam("f") == "html" ||
+		c.QueryParam("f") == "" && strings.Contains(c.Request().Header.Get("Accept"), mediaTypeHTML)
+}
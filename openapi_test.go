@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestGetAPI(t *testing.T) {
+	serveDatahub(t, &fakeDatahub{})
+	tests := []struct {
+		query       string
+		accept      string
+		contentType string
+	}{
+		{query: "", contentType: mediaTypeOpenAPI},
+		{query: "f=json", accept: "text/html", contentType: mediaTypeOpenAPI},
+		{query: "f=html", contentType: mediaTypeHTML},
+		{query: "", accept: "text/html,application/xhtml+xml", contentType: mediaTypeHTML},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/api?"+test.query, nil)
+		req.Header.Set("Accept", test.accept)
+		rec := httptest.NewRecorder()
+		if err := getAPI(echo.New().NewContext(req, rec)); err != nil {
+			t.Fatalf("%s: %v", test.query, err)
+		}
+		if contentType := rec.Header().Get("Content-Type"); !strings.HasPrefix(contentType, test.contentType) {
+			t.Errorf("%q accepting %q: got %s", test.query, test.accept, contentType)
+		}
+		// Swagger UI is loaded from one release, not whatever the latest release is
+		if test.contentType == mediaTypeHTML && !regexp.MustCompile(`swagger-ui-dist@\d+\.\d+\.\d+/`).MatchString(rec.Body.String()) {
+			t.Errorf("%q accepting %q: Swagger UI is not pinned to a release", test.query, test.accept)
+		}
+	}
+
+	// the definition must document every response the handlers give
+	var api map[string]any
+	b, _ := json.Marshal(makeOpenAPI("http://localhost"))
+	if err := json.Unmarshal(b, &api); err != nil {
+		t.Fatal(err)
+	}
+	content := api["paths"].(map[string]any)["/api"].(map[string]any)["get"].(map[string]any)["responses"].(map[string]any)["200"].(map[string]any)["content"].(map[string]any)
+	for _, mediaType := range []string{mediaTypeOpenAPI, mediaTypeHTML} {
+		if _, found := content[mediaType]; !found {
+			t.Errorf("/api does not document %s", mediaType)
+		}
+	}
+	parameters := api["components"].(map[string]any)["parameters"].(map[string]any)
+	for _, name := range []string{"crs", "bbox-crs", "filter-crs"} {
+		schema := parameters[name].(map[string]any)["schema"].(map[string]any)
+		if _, found := schema["format"]; found {
+			t.Errorf("%s declares a format, but EPSG:n is accepted too", name)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strconv"
+)
+
+const (
+	// upstreamPageSize is the number of entities read from the UDA endpoint at a time
+	upstreamPageSize = 1000
+	// defaultItemsLimit and maxItemsLimit bound the number of items in a response
+	defaultItemsLimit = 100
+	maxItemsLimit     = 1000
+	// maxUpstreamPages bounds the pages read from the UDA endpoint for one response. A response
+	// may hold fewer items than the limit when it is reached.
+	maxUpstreamPages = 10
+)
+
+// itemsCursor is the position in a collection where a page of items starts. It wraps the UDA
+// continuation token of the changes holding the next item, the number of entities read with
+// it, the number of items of those changes already returned and the number of items before the
+// position. Offset is what is left of the offset of the request when the response ended before
+// all of it was skipped.
+type itemsCursor struct {
+	Since    string `json:"s,omitempty"`
+	PageSize int    `json:"p,omitempty"`
+	Skip     int    `json:"k,omitempty"`
+	Position int    `json:"n,omitempty"`
+	Offset   int    `json:"o,omitempty"`
+}
+
+func (cursor *itemsCursor) encode() string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeItemsCursor(value string) (*itemsCursor, error) {
+	cursor := &itemsCursor{}
+	if value == "" {
+		return cursor, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	if err := json.Unmarshal(data, cursor); err != nil || cursor.Skip < 0 || cursor.Position < 0 ||
+		cursor.Offset < 0 || cursor.PageSize < 0 || cursor.PageSize > upstreamPageSize {
+		return nil, errors.New("invalid cursor")
+	}
+	return cursor, nil
+}
+
+// parseLimit reads a limit parameter. A missing limit is the default and a limit above the
+// maximum is lowered to the maximum.
+func parseLimit(value string, defaultLimit int, maxLimit int) (int, error) {
+	if value == "" {
+		return defaultLimit, nil
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 1 {
+		return 0, errors.New("limit must be a positive integer")
+	}
+	if limit > maxLimit {
+		limit = maxLimit
+	}
+	return limit, nil
+}
+
+// parseOffset reads an offset parameter, the number of items to skip
+func parseOffset(value string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+	offset, err := strconv.Atoi(value)
+	if err != nil || offset < 0 {
+		return 0, errors.New("offset must be a non-negative integer")
+	}
+	return offset, nil
+}
+
+// itemsPage is a page of items, with the cursor of the next page unless it is the last one
+type itemsPage struct {
+	Features []*Feature
+	Next     *itemsCursor
+	// NumberMatched is the number of items in the collection, only known on the last page
+	NumberMatched int
+}
+
+// readItems reads the items of the dataset matching the filters from the cursor on, skipping
+// offset items, until the limit is reached. Pages of changes are read from the UDA endpoint
+// until there are enough items, the end of the dataset is reached or maxUpstreamPages pages
+// have been read.
+func readItems(ds *Dataset, baseUrl string, cursor *itemsCursor, limit int, offset int, filters []featureFilter) (*itemsPage, error) {
+	page := &itemsPage{Features: make([]*Feature, 0, limit)}
+	since, skip, position := cursor.Since, cursor.Skip, cursor.Position
+	offset += cursor.Offset
+	// the cursor skips items of changes read with its page size
+	pageSize := cursor.PageSize
+	for pages := 0; ; pages++ {
+		if pages == maxUpstreamPages {
+			page.Next = &itemsCursor{Since: since, Position: position, Offset: offset}
+			return page, nil
+		}
+
+		if pageSize == 0 {
+			pageSize = itemsPageSize(ds, limit, offset, filters)
+		}
+		ec, err := fetchChanges(ds.RemoteDataset, since, pageSize)
+		if err != nil {
+			return nil, err
+		}
+		items, err := makeItems(ec, ds, baseUrl)
+		if err != nil {
+			return nil, err
+		}
+		// skip counts the matching items, so a cursor must be used with the same filters
+		features := make([]*Feature, 0, len(items))
+		for _, f := range items {
+			if matchesAll(f, filters) {
+				features = append(features, f)
+			}
+		}
+
+		for skip < len(features) {
+			if len(page.Features) == limit {
+				page.Next = &itemsCursor{Since: since, PageSize: pageSize, Skip: skip, Position: position}
+				return page, nil
+			}
+			if offset > 0 {
+				offset--
+			} else {
+				page.Features = append(page.Features, features[skip])
+			}
+			skip++
+			position++
+		}
+
+		// a page without entities is the end of the dataset
+		if len(ec.Entities) == 0 || ec.Continuation == nil || ec.Continuation.Token == "" {
+			page.NumberMatched = position
+			return page, nil
+		}
+		since, skip, pageSize = ec.Continuation.Token, 0, 0
+	}
+}
+
+// itemsPageSize is the number of entities to read from the UDA endpoint at a time. Pages of the
+// size of the limit are enough for plain pages of items, while skipping an offset or filtering
+// reads full pages. The members of feature collections are mostly found in the same page of
+// changes, which saves looking them up in the cache of the dataset.
+func itemsPageSize(ds *Dataset, limit int, offset int, filters []featureFilter) int {
+	if ds.Type == "featurecollections" || offset > 0 || len(filters) > 0 {
+		return upstreamPageSize
+	}
+	return limit
+}
+
+// pageUrl is the URL of the request with the paging parameters replaced by the cursor, which
+// holds what is left of the offset
+func pageUrl(requestUrl string, query url.Values, cursor *itemsCursor, limit int) string {
+	values := url.Values{}
+	for k, v := range query {
+		values[k] = v
+	}
+	values.Del("offset")
+	values.Set("cursor", cursor.encode())
+	values.Set("limit", strconv.Itoa(limit))
+	return requestUrl + "?" + values.Encode()
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+// pointEntities are n entities with a point geometry and a Quantity equal to their index
+func pointEntities(n int) []string {
+	entities := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		entities = append(entities, fmt.Sprintf(`{"http://data.mimiro.io/models/flatgeo/wkt":"POINT (%d 60)","http://data.example.org/Quantity":%d}`, i, i))
+	}
+	return entities
+}
+
+func TestReadItems(t *testing.T) {
+	serveDatahub(t, &fakeDatahub{datasets: map[string][]string{"points": pointEntities(25)}})
+	ds := &Dataset{Name: "points", Type: "features", RemoteDataset: "points", Dimensions: 2}
+	evenFilter := func(f *Feature) bool {
+		q, _ := f.Properties["http://data.example.org/Quantity"].(float64)
+		return int(q)%2 == 0
+	}
+
+	tests := []struct {
+		name    string
+		limit   int
+		offset  int
+		filters []featureFilter
+		pages   [][]int
+		matched int
+	}{
+		{name: "pages of 10", limit: 10, pages: [][]int{{0, 9}, {10, 19}, {20, 24}}, matched: 25},
+		{name: "one page", limit: 100, pages: [][]int{{0, 24}}, matched: 25},
+		{name: "a page ending with the dataset", limit: 5, offset: 20, pages: [][]int{{20, 24}}, matched: 25},
+		{name: "offset", limit: 10, offset: 7, pages: [][]int{{7, 16}, {17, 24}}, matched: 25},
+		{name: "offset past the end", limit: 10, offset: 30, pages: [][]int{{}}, matched: 25},
+		{name: "filtered", limit: 5, offset: 1, filters: []featureFilter{evenFilter}, pages: [][]int{{2, 10}, {12, 20}, {22, 24}}, matched: 13},
+	}
+	for _, test := range tests {
+		cursor := &itemsCursor{}
+		for i, expected := range test.pages {
+			page, err := readItems(ds, "", cursor, test.limit, test.offset, test.filters)
+			if err != nil {
+				t.Fatalf("%s: %v", test.name, err)
+			}
+			ids := make([]int, 0, len(page.Features))
+			for _, f := range page.Features {
+				var id int
+				fmt.Sscanf(f.Id, "http://data.example.org/%d", &id)
+				ids = append(ids, id)
+			}
+			if len(expected) == 0 && len(ids) != 0 ||
+				len(expected) == 2 && (len(ids) == 0 || ids[0] != expected[0] || ids[len(ids)-1] != expected[1]) {
+				t.Errorf("%s: page %d holds %v, expected %v", test.name, i, ids, expected)
+			}
+
+			last := i == len(test.pages)-1
+			if last != (page.Next == nil) {
+				t.Errorf("%s: page %d has next %v", test.name, i, page.Next)
+				break
+			}
+			if last {
+				// the number matched counts the skipped items too
+				if page.NumberMatched != test.matched {
+					t.Errorf("%s: got numberMatched %d", test.name, page.NumberMatched)
+				}
+				break
+			}
+			// the next page is read from the cursor alone, as in a next link without offset
+			cursor, err = decodeItemsCursor(page.Next.encode())
+			if err != nil {
+				t.Fatalf("%s: %v", test.name, err)
+			}
+			test.offset = 0
+		}
+	}
+}
+
+func TestDecodeItemsCursor(t *testing.T) {
+	cursor := &itemsCursor{Since: "abc", PageSize: 10, Skip: 3, Position: 13, Offset: 2}
+	decoded, err := decodeItemsCursor(cursor.encode())
+	if err != nil || *decoded != *cursor {
+		t.Errorf("got %v, %v, expected %v", decoded, err, cursor)
+	}
+
+	for _, value := range []string{"%%", "bm90IGpzb24", (&itemsCursor{Skip: -1}).encode(), (&itemsCursor{PageSize: upstreamPageSize + 1}).encode()} {
+		if _, err := decodeItemsCursor(value); err == nil {
+			t.Errorf("%s: expected an error", value)
+		}
+	}
+}
+
+func TestParseLimit(t *testing.T) {
+	tests := []struct {
+		value string
+		limit int
+		err   bool
+	}{
+		{value: "", limit: defaultItemsLimit},
+		{value: "10", limit: 10},
+		{value: "100000", limit: maxItemsLimit},
+		{value: "0", err: true},
+		{value: "-1", err: true},
+		{value: "ten", err: true},
+	}
+	for _, test := range tests {
+		limit, err := parseLimit(test.value, defaultItemsLimit, maxItemsLimit)
+		if test.err != (err != nil) || limit != test.limit {
+			t.Errorf("%q: got %d, %v", test.value, limit, err)
+		}
+	}
+}
@@ -0,0 +1,536 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PropertyMapping decides which entity properties become feature properties and how. Properties
+// are named by their full URI or their local name.
+type PropertyMapping struct {
+	// Include lists the properties to publish, all properties are published when it is empty
+	Include []string `json:"include,omitempty"`
+	// Exclude lists properties that are never published
+	Exclude  []string       `json:"exclude,omitempty"`
+	Mappings []*PropertyMap `json:"mappings,omitempty"`
+}
+
+// PropertyMap renames a property and converts its value to a type. The default is used when
+// the entity does not have the property or its value cannot be converted.
+type PropertyMap struct {
+	Property string `json:"property"`
+	Name     string `json:"name,omitempty"`
+	Type     string `json:"type,omitempty"`
+	Format   string `json:"format,omitempty"`
+	Default  any    `json:"default,omitempty"`
+}
+
+var propertyKeyModes = map[string]bool{propertyKeysURI: true, propertyKeysLocal: true, propertyKeysCURIE: true}
+
+var propertyTypes = map[string]bool{"": true, "string": true, "int": true, "float": true, "bool": true, "date": true}
+
+// propertyMapping is the property mapping of the dataset, or an empty mapping publishing all properties
+func (ds *Dataset) propertyMapping() *PropertyMapping {
+	if ds.PropertyMapping == nil {
+		return &PropertyMapping{}
+	}
+	return ds.PropertyMapping
+}
+
+func (pm *PropertyMapping) validate() error {
+	for _, m := range pm.Mappings {
+		if m.Property == "" {
+			return errors.New("property mapping without property")
+		}
+		if !propertyTypes[m.Type] {
+			return fmt.Errorf("unknown type %s in property mapping of %s", m.Type, m.Property)
+		}
+	}
+	return nil
+}
+
+func matchesProperty(uri string, name string) bool {
+	return uri == name || stripUrl(uri) == name
+}
+
+func (pm *PropertyMapping) lookup(uri string) *PropertyMap {
+	for _, m := range pm.Mappings {
+		if matchesProperty(uri, m.Property) {
+			return m
+		}
+	}
+	return nil
+}
+
+func (pm *PropertyMapping) isPublished(uri string) bool {
+	for _, name := range pm.Exclude {
+		if matchesProperty(uri, name) {
+			return false
+		}
+	}
+	if len(pm.Include) == 0 || pm.lookup(uri) != nil {
+		return true
+	}
+	for _, name := range pm.Include {
+		if matchesProperty(uri, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// queryables are the names of the properties features can be filtered on: the mapped and the
+// included properties of the dataset. It is nil for datasets configuring neither, on which all
+// properties can be filtered on.
+func (ds *Dataset) queryables() []string {
+	pm := ds.propertyMapping()
+	if len(pm.Mappings) == 0 && len(pm.Include) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(pm.Mappings)+len(pm.Include))
+	seen := make(map[string]bool)
+	for _, m := range pm.Mappings {
+		name := m.Name
+		if name == "" {
+			name = m.Property
+		}
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	for _, name := range pm.Include {
+		if !seen[name] && pm.lookup(name) == nil {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// lookupQueryable finds the queryable property with the name, either the name given to it
+// by the mapping, its full URI or its local name. The mapping is nil for included properties
+// and for datasets without queryables, on which the name must be that of a property seen in
+// the entities of the dataset.
+func (ds *Dataset) lookupQueryable(name string) (*PropertyMap, bool) {
+	if name == "" {
+		return nil, false
+	}
+	pm := ds.propertyMapping()
+	for _, m := range pm.Mappings {
+		if (m.Name != "" && name == m.Name) || matchesProperty(m.Property, name) {
+			return m, true
+		}
+	}
+	for _, include := range pm.Include {
+		if matchesProperty(include, name) {
+			return nil, true
+		}
+	}
+	if ds.queryables() != nil {
+		return nil, false
+	}
+	if ds.hasSeenProperty(name) {
+		return nil, true
+	}
+	// properties are only seen once entities have been read
+	if _, err := ds.sampleFeatures(""); err != nil {
+		log.Printf("dataset %s: unable to sample the properties: %v", ds.Name, err)
+	}
+	return nil, ds.hasSeenProperty(name)
+}
+
+// knownQueryables are the queryables of the dataset, or the local names of the properties seen
+// so far for datasets without queryables
+func (ds *Dataset) knownQueryables() []string {
+	if names := ds.queryables(); names != nil {
+		return names
+	}
+	seen := make(map[string]bool)
+	ds.seenProperties.Range(func(uri, _ any) bool {
+		seen[stripUrl(uri.(string))] = true
+		return true
+	})
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// observeProperties remembers the published properties of the entities, and the references
+// published as properties, as those filters can use on datasets without queryables
+func (ds *Dataset) observeProperties(ec *EntityCollection) {
+	pm := ds.propertyMapping()
+	uris := make(map[string]bool)
+	for _, e := range ec.Entities {
+		for uri := range e.Properties {
+			uris[uri] = true
+		}
+		if ds.referenceMode() == referencesProperties {
+			for uri := range e.References {
+				if !flatgeoReferences[uri] {
+					uris[uri] = true
+				}
+			}
+		}
+	}
+	for uri := range uris {
+		if uri != flatgeoBboxPredicate && pm.isPublished(uri) {
+			ds.seenProperties.Store(uri, true)
+		}
+	}
+}
+
+// hasSeenProperty is true when a property seen in the entities has the name as its full URI,
+// its local name or, for CURIEs, the local name of the CURIE
+func (ds *Dataset) hasSeenProperty(name string) bool {
+	if isCURIE, _, local := isCURIE(name); isCURIE {
+		name = local
+	}
+	found := false
+	ds.seenProperties.Range(func(uri, _ any) bool {
+		found = matchesProperty(uri.(string), name)
+		return !found
+	})
+	return found
+}
+
+const (
+	propertyKeysURI   = "uri"
+	propertyKeysLocal = "local"
+	propertyKeysCURIE = "curie"
+)
+
+// propertyKeyMode is the propertyKeys setting of the dataset, or the mode given by
+// stripPropertyUrls when it is not set
+func (ds *Dataset) propertyKeyMode() string {
+	if ds.PropertyKeys != "" {
+		return ds.PropertyKeys
+	}
+	if ds.StripPropertyUrls {
+		return propertyKeysLocal
+	}
+	return propertyKeysURI
+}
+
+// propertyNamer turns property URIs into feature property keys. Keys are the full URI, the
+// local name or the CURIE of the property depending on the mode of the dataset. When the local
+// names of different URIs collide, each of them is given its CURIE, or its full URI when the
+// namespace has no prefix, so that no value overwrites another. Collisions are decided once per
+// property by the dataset, see assignPropertyKeys, so a property keeps its key from one page to
+// the next.
+type propertyNamer struct {
+	mode      string
+	context   *Context
+	colliding map[string]bool
+}
+
+func newPropertyNamer(ds *Dataset, ec *EntityCollection) *propertyNamer {
+	pn := &propertyNamer{mode: ds.propertyKeyMode(), context: ec.Context, colliding: make(map[string]bool)}
+	if pn.mode != propertyKeysLocal {
+		return pn
+	}
+	ds.samplePropertyKeys()
+	pn.colliding = ds.assignPropertyKeys(ds.localPropertyKeys(ec.Entities))
+	return pn
+}
+
+// propertyKeysSampleSize is the number of entities read to find the properties whose local
+// names collide before any feature is published
+const propertyKeysSampleSize = 1000
+
+// samplePropertyKeys decides the collisions of the properties of the first entities of the
+// dataset, so that properties colliding there are published by their CURIE from the first page on
+func (ds *Dataset) samplePropertyKeys() {
+	ds.propertyKeysSample.ensure(ds, "property keys", func() error {
+		ec, err := fetchChanges(ds.RemoteDataset, "", propertyKeysSampleSize)
+		if err != nil {
+			return err
+		}
+		ds.assignPropertyKeys(ds.localPropertyKeys(ec.Entities))
+		return nil
+	})
+}
+
+// localPropertyKeys are the property URIs of the entities, including those of their nested
+// entities and references published as properties, by their local key
+func (ds *Dataset) localPropertyKeys(entities []*Entity) map[string]map[string]bool {
+	pm := ds.propertyMapping()
+	urisByKey := make(map[string]map[string]bool)
+	var addKeys func(e *Entity, nested bool)
+	addKey := func(uri string) {
+		key := stripUrl(uri)
+		if m := pm.lookup(uri); m != nil && m.Name != "" {
+			key = m.Name
+		}
+		if urisByKey[key] == nil {
+			urisByKey[key] = make(map[string]bool)
+		}
+		urisByKey[key][uri] = true
+	}
+	// nested entities are named as the entities holding them
+	var addNestedKeys func(value any)
+	addNestedKeys = func(value any) {
+		switch v := value.(type) {
+		case *Entity:
+			addKeys(v, true)
+		case []any:
+			for _, item := range v {
+				addNestedKeys(item)
+			}
+		}
+	}
+	addKeys = func(e *Entity, nested bool) {
+		for uri, value := range e.Properties {
+			addKey(uri)
+			addNestedKeys(value)
+		}
+		// references published as properties share the keys of the properties, the references
+		// of nested entities are always published as properties
+		mode := ds.referenceMode()
+		if mode == referencesProperties || nested && mode != referencesNone {
+			for uri := range e.References {
+				if !flatgeoReferences[uri] {
+					addKey(uri)
+				}
+			}
+		}
+	}
+	for _, e := range entities {
+		addKeys(e, false)
+	}
+	return urisByKey
+}
+
+// assignPropertyKeys decides, once for each property URI not seen before, whether it is
+// published by its local key or, colliding, by its CURIE. A key seen with one URI belongs to that
+// URI from then on, and URIs found later with the same key collide. When a key is first seen
+// with several URIs at once, none of them gets it. It returns which of the given URIs collide.
+func (ds *Dataset) assignPropertyKeys(urisByKey map[string]map[string]bool) map[string]bool {
+	ds.propertyKeysMu.Lock()
+	defer ds.propertyKeysMu.Unlock()
+	if ds.propertyKeyOwners == nil {
+		ds.propertyKeyOwners = make(map[string]string)
+		ds.collidingProperties = make(map[string]bool)
+	}
+
+	colliding := make(map[string]bool)
+	for key, uris := range urisByKey {
+		owner, owned := ds.propertyKeyOwners[key]
+		added := make([]string, 0, len(uris))
+		for uri := range uris {
+			if uri != owner && !ds.collidingProperties[uri] {
+				added = append(added, uri)
+			}
+		}
+		if len(added) == 1 && !owned {
+			ds.propertyKeyOwners[key] = added[0]
+		} else if len(added) > 0 {
+			if !owned {
+				// a key first seen with several URIs belongs to none of them
+				ds.propertyKeyOwners[key] = ""
+			}
+			sort.Strings(added)
+			for _, uri := range added {
+				ds.collidingProperties[uri] = true
+			}
+			if owner != "" {
+				ds.warnOnce("collision "+strings.Join(added, " "), "properties %s share the key %s of %s, they are published by their CURIE",
+					strings.Join(added, ", "), key, owner)
+			} else {
+				ds.warnOnce("collision "+strings.Join(added, " "), "properties %s share the key %s, they are published by their CURIE",
+					strings.Join(added, ", "), key)
+			}
+		}
+		for uri := range uris {
+			if ds.collidingProperties[uri] {
+				colliding[uri] = true
+			}
+		}
+	}
+	return colliding
+}
+
+func (pn *propertyNamer) name(uri string) string {
+	if !isFullURI(uri) {
+		return uri
+	}
+	switch {
+	case pn.mode == propertyKeysLocal && !pn.colliding[uri]:
+		return stripUrl(uri)
+	case pn.mode == propertyKeysLocal || pn.mode == propertyKeysCURIE:
+		if curie, err := pn.context.GetCURIE(uri); err == nil {
+			return curie
+		}
+	}
+	return uri
+}
+
+// key is the name of the property in the mapping, unless it collides, or its name by the mode
+func (pn *propertyNamer) key(uri string, pm *PropertyMapping) string {
+	if m := pm.lookup(uri); m != nil && m.Name != "" && !pn.colliding[uri] {
+		return m.Name
+	}
+	return pn.name(uri)
+}
+
+// makeFeatureProperties maps the entity properties to feature properties following the
+// property mapping of the dataset. Values that cannot be converted to their mapped type are
+// reported and replaced by the default of the mapping, or kept as they are without a default.
+func makeFeatureProperties(e *Entity, ds *Dataset, namer *propertyNamer, baseUrl string) map[string]interface{} {
+	properties, mapped := makePropertyMap(e, ds, namer, baseUrl)
+
+	// mapped properties missing on the entity get their default
+	pm := ds.propertyMapping()
+	for _, m := range pm.Mappings {
+		if !mapped[m] && m.Default != nil {
+			name := m.Name
+			if name == "" {
+				name = namer.name(m.Property)
+			}
+			properties[name] = m.Default
+		}
+	}
+	return properties
+}
+
+// makePropertyMap maps the published properties of the entity and returns the mappings used
+func makePropertyMap(e *Entity, ds *Dataset, namer *propertyNamer, baseUrl string) (map[string]interface{}, map[*PropertyMap]bool) {
+	properties := make(map[string]interface{})
+	pm := ds.propertyMapping()
+
+	keys := make([]string, 0, len(e.Properties))
+	for k := range e.Properties {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	mapped := make(map[*PropertyMap]bool)
+	for _, k := range keys {
+		if k == flatgeoBboxPredicate || !pm.isPublished(k) {
+			continue
+		}
+		v := e.Properties[k]
+		name := namer.key(k, pm)
+		if m := pm.lookup(k); m != nil {
+			mapped[m] = true
+			converted, err := convertPropertyValue(v, m)
+			if err != nil {
+				ds.warnEvery("property "+m.Property, "entity %s: property %s: %v", e.ID, m.Property, err)
+				if m.Default != nil {
+					converted = m.Default
+				} else {
+					converted = v
+				}
+			}
+			v = converted
+		}
+		properties[name] = makeNestedValue(v, ds, namer, baseUrl)
+	}
+	return properties, mapped
+}
+
+// makeNestedValue renders nested entities, also inside lists, as plain objects. Their
+// properties follow the same naming and mapping as the properties of the feature, their
+// references are published as properties unless the dataset leaves references out, and
+// their id, when they have one, is given as id.
+func makeNestedValue(value any, ds *Dataset, namer *propertyNamer, baseUrl string) any {
+	switch v := value.(type) {
+	case *Entity:
+		object, _ := makePropertyMap(v, ds, namer, baseUrl)
+		if ds.referenceMode() != referencesNone {
+			addReferenceProperties(object, v, ds, namer, baseUrl)
+		}
+		if _, taken := object["id"]; v.ID != "" && !taken {
+			object["id"] = v.ID
+		}
+		return object
+	case []any:
+		values := make([]any, len(v))
+		for i, item := range v {
+			values[i] = makeNestedValue(item, ds, namer, baseUrl)
+		}
+		return values
+	}
+	return value
+}
+
+// convertPropertyValue converts the value, or each value of a list, to the type of the mapping
+func convertPropertyValue(value any, m *PropertyMap) (any, error) {
+	if list, ok := value.([]any); ok && len(list) != 1 {
+		converted := make([]any, 0, len(list))
+		for _, v := range list {
+			c, err := convertPropertyValue(v, m)
+			if err != nil {
+				return nil, err
+			}
+			converted = append(converted, c)
+		}
+		return converted, nil
+	}
+
+	switch m.Type {
+	case "int":
+		f, err := toNumber(value)
+		if err != nil {
+			return nil, err
+		}
+		if f != math.Trunc(f) {
+			return nil, fmt.Errorf("not an integer: %v", value)
+		}
+		return int64(f), nil
+	case "float":
+		return toNumber(value)
+	case "bool":
+		return toBool(value)
+	case "date":
+		t, err := parseTime(value, m.Format)
+		if err != nil {
+			return nil, err
+		}
+		if hasClock(m.Format, value) {
+			return t.Format(time.RFC3339Nano), nil
+		}
+		return t.Format("2006-01-02"), nil
+	case "string":
+		if list, ok := value.([]any); ok {
+			value = list[0]
+		}
+		if f, ok := value.(float64); ok {
+			return strconv.FormatFloat(f, 'f', -1, 64), nil
+		}
+		return fmt.Sprint(value), nil
+	}
+	return value, nil
+}
+
+func toBool(value any) (bool, error) {
+	switch v := value.(type) {
+	case bool:
+		return v, nil
+	case float64:
+		if v == 0 || v == 1 {
+			return v == 1, nil
+		}
+	case string:
+		switch strings.ToLower(strings.TrimSpace(v)) {
+		case "true", "yes", "y", "1":
+			return true, nil
+		case "false", "no", "n", "0":
+			return false, nil
+		}
+	case []any:
+		if len(v) == 1 {
+			return toBool(v[0])
+		}
+	}
+	return false, fmt.Errorf("not a boolean: %v", value)
+}
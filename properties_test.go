@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAssignPropertyKeys(t *testing.T) {
+	const (
+		year     = "http://data.example.org/Year"
+		yearNs5  = "http://ns5.example.org/Year"
+		yearNs6  = "http://ns6.example.org/Year"
+		name     = "http://data.example.org/Name"
+		nameNs5  = "http://ns5.example.org/Name"
+		quantity = "http://data.example.org/Quantity"
+	)
+	// each batch is assigned in order, and the colliding URIs are those after the batch
+	batches := []struct {
+		name      string
+		uris      []string
+		colliding []string
+	}{
+		{name: "sample", uris: []string{year, yearNs5, name}, colliding: []string{year, yearNs5}},
+		{name: "a third URI with a contested key", uris: []string{year, yearNs6}, colliding: []string{year, yearNs6}},
+		{name: "a URI with the key of another", uris: []string{name, nameNs5, quantity}, colliding: []string{nameNs5}},
+		{name: "the owner alone keeps its key", uris: []string{name}},
+		{name: "the colliding URI alone keeps its CURIE", uris: []string{nameNs5}, colliding: []string{nameNs5}},
+	}
+
+	ds := &Dataset{Name: "test"}
+	for _, batch := range batches {
+		urisByKey := make(map[string]map[string]bool)
+		for _, uri := range batch.uris {
+			key := stripUrl(uri)
+			if urisByKey[key] == nil {
+				urisByKey[key] = make(map[string]bool)
+			}
+			urisByKey[key][uri] = true
+		}
+		colliding := ds.assignPropertyKeys(urisByKey)
+		if len(colliding) != len(batch.colliding) {
+			t.Errorf("%s: got colliding %v, expected %v", batch.name, colliding, batch.colliding)
+			continue
+		}
+		for _, uri := range batch.colliding {
+			if !colliding[uri] {
+				t.Errorf("%s: got colliding %v, expected %v", batch.name, colliding, batch.colliding)
+				break
+			}
+		}
+	}
+}
+
+func TestPropertyKeysFromSample(t *testing.T) {
+	hub := &fakeDatahub{datasets: map[string][]string{
+		"years": {
+			`{"http://data.example.org/Year":2020}`,
+			`{"http://ns5.example.org/Year":2021}`,
+		},
+	}}
+	serveDatahub(t, hub)
+	ds := &Dataset{Name: "years", Type: "features", RemoteDataset: "years", StripPropertyUrls: true}
+
+	// a page holding only one of the colliding properties already names it by its CURIE
+	ec := NewEntityCollection()
+	ec.Context.StorePrefixExpansionMapping("ex", "http://data.example.org/")
+	ec.Entities = append(ec.Entities, &Entity{
+		ID:         "http://data.example.org/0",
+		Properties: map[string]any{"http://data.example.org/Year": 2020.0, "http://data.example.org/Name": "a"},
+		References: map[string]any{},
+	})
+	namer := newPropertyNamer(ds, ec)
+	if key := namer.name("http://data.example.org/Year"); key != "ex:Year" {
+		t.Errorf("got key %s for a property colliding in the sample", key)
+	}
+	if key := namer.name("http://data.example.org/Name"); key != "Name" {
+		t.Errorf("got key %s for a property without collisions", key)
+	}
+}
+
+func TestConvertPropertyValue(t *testing.T) {
+	tests := []struct {
+		value     any
+		mapping   PropertyMap
+		converted any
+	}{
+		{value: 100.0, mapping: PropertyMap{Type: "int"}, converted: int64(100)},
+		{value: "100", mapping: PropertyMap{Type: "int"}, converted: int64(100)},
+		{value: []any{"100"}, mapping: PropertyMap{Type: "int"}, converted: int64(100)},
+		{value: []any{1.0, "2"}, mapping: PropertyMap{Type: "int"}, converted: []any{int64(1), int64(2)}},
+		{value: "1.5", mapping: PropertyMap{Type: "float"}, converted: 1.5},
+		{value: "1,5", mapping: PropertyMap{Type: "float"}, converted: 1.5},
+		{value: "yes", mapping: PropertyMap{Type: "bool"}, converted: true},
+		{value: 0.0, mapping: PropertyMap{Type: "bool"}, converted: false},
+		{value: " N ", mapping: PropertyMap{Type: "bool"}, converted: false},
+		{value: 2011.0, mapping: PropertyMap{Type: "string"}, converted: "2011"},
+		{value: []any{true}, mapping: PropertyMap{Type: "string"}, converted: "true"},
+		{value: "2011-07-01", mapping: PropertyMap{Type: "date"}, converted: "2011-07-01"},
+		{value: "01/07/2011", mapping: PropertyMap{Type: "date", Format: "02/01/2006"}, converted: "2011-07-01"},
+		{value: "2011-07-01T11:00:00+02:00", mapping: PropertyMap{Type: "date"}, converted: "2011-07-01T09:00:00Z"},
+		{value: 1309510800.0, mapping: PropertyMap{Type: "date", Format: "unix"}, converted: "2011-07-01T09:00:00Z"},
+		{value: "as is", mapping: PropertyMap{}, converted: "as is"},
+	}
+	for _, test := range tests {
+		converted, err := convertPropertyValue(test.value, &test.mapping)
+		if err != nil {
+			t.Errorf("%v to %q: %v", test.value, test.mapping.Type, err)
+			continue
+		}
+		if !reflect.DeepEqual(converted, test.converted) {
+			t.Errorf("%v to %q: got %#v, expected %#v", test.value, test.mapping.Type, converted, test.converted)
+		}
+	}
+
+	invalid := []struct {
+		value   any
+		mapping PropertyMap
+	}{
+		{value: 1.5, mapping: PropertyMap{Type: "int"}},
+		{value: "many", mapping: PropertyMap{Type: "int"}},
+		{value: "1,000", mapping: PropertyMap{Type: "float"}},
+		{value: []any{1.0, "x"}, mapping: PropertyMap{Type: "float"}},
+		{value: 2.0, mapping: PropertyMap{Type: "bool"}},
+		{value: "maybe", mapping: PropertyMap{Type: "bool"}},
+		{value: "01/07/2011", mapping: PropertyMap{Type: "date"}},
+	}
+	for _, test := range invalid {
+		if _, err := convertPropertyValue(test.value, &test.mapping); err == nil {
+			t.Errorf("%v to %q: expected an error", test.value, test.mapping.Type)
+		}
+	}
+}
+
+func TestMakeFeatureProperties(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	ds := &Dataset{Name: "mapped", PropertyMapping: &PropertyMapping{
+		Include: []string{"Species", "http://data.example.org/Note"},
+		Exclude: []string{"Note"},
+		Mappings: []*PropertyMap{
+			{Property: "Quantity", Type: "int", Default: int64(0)},
+			{Property: "http://data.example.org/Active", Name: "active", Type: "bool"},
+			{Property: "Depth", Type: "float", Default: -1.0},
+			{Property: "Observed", Name: "observed", Type: "date"},
+		},
+	}}
+	namer := &propertyNamer{mode: propertyKeysLocal, context: NewEntityCollection().Context, colliding: map[string]bool{}}
+	tests := []struct {
+		name       string
+		values     map[string]any
+		properties map[string]any
+		warning    string
+	}{
+		{
+			name: "converted and renamed",
+			values: map[string]any{
+				"http://data.example.org/Species":  "Aurelia aurita",
+				"http://data.example.org/Quantity": "12",
+				"http://data.example.org/Active":   "yes",
+				"http://data.example.org/Depth":    "3,5",
+				"http://data.example.org/Observed": "2011-07-01",
+				"http://data.example.org/Note":     "excluded",
+				"http://data.example.org/Colour":   "not included",
+			},
+			properties: map[string]any{
+				"Species": "Aurelia aurita", "Quantity": int64(12), "active": true, "Depth": 3.5, "observed": "2011-07-01",
+			},
+		},
+		{
+			name:   "missing properties get their default",
+			values: map[string]any{"http://data.example.org/Species": "Aurelia aurita"},
+			properties: map[string]any{
+				"Species": "Aurelia aurita", "Quantity": int64(0), "Depth": -1.0,
+			},
+		},
+		{
+			name: "failed conversions get their default or keep their value",
+			values: map[string]any{
+				"http://data.example.org/Quantity": "many",
+				"http://data.example.org/Active":   "maybe",
+			},
+			properties: map[string]any{"Quantity": int64(0), "active": "maybe", "Depth": -1.0},
+			warning:    "entity http://data.example.org/1: property Quantity: not a number: many",
+		},
+	}
+	for _, test := range tests {
+		buf.Reset()
+		properties := makeFeatureProperties(testEntity("1", test.values), ds, namer, "http://localhost")
+		if !reflect.DeepEqual(properties, test.properties) {
+			t.Errorf("%s: got %#v, expected %#v", test.name, properties, test.properties)
+		}
+		if test.warning != "" && !strings.Contains(buf.String(), test.warning) {
+			t.Errorf("%s: got log %q, expected %q", test.name, buf.String(), test.warning)
+		}
+	}
+
+	// failures of a property are logged once a minute
+	buf.Reset()
+	makeFeatureProperties(testEntity("2", map[string]any{"http://data.example.org/Quantity": "few"}), ds, namer, "http://localhost")
+	if buf.Len() != 0 {
+		t.Errorf("a second failure within a minute was logged: %s", buf.String())
+	}
+}
+
+func TestNestedEntities(t *testing.T) {
+	serveDatahub(t, &fakeDatahub{datasets: map[string][]string{
+		"samples": {`{"ex:Sample":{"id":"ex:samples/1","props":{"ex:Quantity":"12"},"refs":{}}}`},
+	}})
+	RemoteDatahub.Datasets = []*Dataset{{Name: "species", IdNamespace: "http://data.example.org/species/"}}
+
+	sample := &Entity{
+		ID: "http://data.example.org/samples/1",
+		Properties: map[string]any{
+			"http://data.example.org/Quantity": "12",
+			"http://data.example.org/Note":     "excluded",
+		},
+		References: map[string]any{"http://data.example.org/species": "http://data.example.org/species/1"},
+	}
+	// nested entities without an id, such as blank nodes, are published without one
+	depth := &Entity{Properties: map[string]any{"http://data.example.org/Value": 3.5}, References: map[string]any{}}
+	e := testEntity("1", map[string]any{
+		"http://data.example.org/Sample": sample,
+		"http://data.example.org/Depths": []any{depth, "unknown"},
+	})
+	mapping := &PropertyMapping{
+		Exclude:  []string{"Note"},
+		Mappings: []*PropertyMap{{Property: "Quantity", Name: "quantity", Type: "int"}},
+	}
+	namer := &propertyNamer{mode: propertyKeysLocal, context: NewEntityCollection().Context, colliding: map[string]bool{}}
+	tests := []struct {
+		references string
+		json       string
+	}{
+		{
+			references: referencesLinks,
+			json: `{"Depths":[{"Value":3.5},"unknown"],"Sample":{"id":"http://data.example.org/samples/1","quantity":12,` +
+				`"species":"http://localhost/datasets/species/features/1"}}`,
+		},
+		{
+			references: referencesNone,
+			json:       `{"Depths":[{"Value":3.5},"unknown"],"Sample":{"id":"http://data.example.org/samples/1","quantity":12}}`,
+		},
+	}
+	for _, test := range tests {
+		ds := &Dataset{Name: "nested", References: test.references, PropertyMapping: mapping}
+		b, err := json.Marshal(makeFeatureProperties(e, ds, namer, "http://localhost"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != test.json {
+			t.Errorf("%s: got %s, expected %s", test.references, b, test.json)
+		}
+	}
+
+	// nested objects read from the UDA endpoint are published the same way
+	ec, err := fetchChanges("samples", "", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ds := &Dataset{Name: "nested", References: referencesNone, PropertyMapping: mapping}
+	b, err := json.Marshal(makeFeatureProperties(ec.Entities[0], ds, namer, "http://localhost"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := `{"Sample":{"id":"http://data.example.org/samples/1","quantity":12}}`; string(b) != expected {
+		t.Errorf("parsed: got %s, expected %s", b, expected)
+	}
+
+	// an id property of a nested entity keeps its value
+	sample.Properties["http://data.example.org/id"] = "S1"
+	ds = &Dataset{Name: "nested", References: referencesNone}
+	properties := makeFeatureProperties(e, ds, namer, "http://localhost")
+	if id := properties["Sample"].(map[string]any)["id"]; id != "S1" {
+		t.Errorf("got id %v, expected the id property", id)
+	}
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// JSON Schemas describing the features of a collection: the queryables of OGC API - Features
+// Part 3, the properties filters can use, and the feature schema of Part 5. Property types come
+// from the property mapping of the dataset and are otherwise inferred from a sample of its
+// features.
+
+const (
+	jsonSchemaDialect = "https://json-schema.org/draft/2019-09/schema"
+	mediaTypeSchema   = "application/schema+json"
+
+	relQueryables = "http://www.opengis.net/def/rel/ogc/1.0/queryables"
+	relSchema     = "http://www.opengis.net/def/rel/ogc/1.0/schema"
+
+	// schemaSampleSize is the number of features read to infer property types
+	schemaSampleSize = 100
+)
+
+type JSONSchema struct {
+	Schema               string                 `json:"$schema,omitempty"`
+	Id                   string                 `json:"$id,omitempty"`
+	Type                 string                 `json:"type,omitempty"`
+	Title                string                 `json:"title,omitempty"`
+	Description          string                 `json:"description,omitempty"`
+	Format               string                 `json:"format,omitempty"`
+	Role                 string                 `json:"x-ogc-role,omitempty"`
+	Items                *JSONSchema            `json:"items,omitempty"`
+	Properties           map[string]*JSONSchema `json:"properties,omitempty"`
+	AdditionalProperties *bool                  `json:"additionalProperties,omitempty"`
+}
+
+func getQueryables(c echo.Context) error {
+	return getSchema(c, true)
+}
+
+func getFeatureSchema(c echo.Context) error {
+	return getSchema(c, false)
+}
+
+func getSchema(c echo.Context, queryables bool) error {
+	ds := lookupDataset(c.Param("collection"))
+	if ds == nil {
+		return c.NoContent(http.StatusNotFound)
+	}
+	baseUrl := requestBaseUrl(c)
+	var sample []*Feature
+	if !ds.mappingDefinesSchema(queryables) {
+		var err error
+		if sample, err = ds.sampleFeatures(baseUrl); err != nil {
+			return upstreamErrorResponse(c, err)
+		}
+	}
+
+	schema := makeFeatureSchema(ds, sample, queryables)
+	schema.Id = baseUrl + c.Request().URL.EscapedPath()
+	return jsonResponse(c, http.StatusOK, mediaTypeSchema, schema)
+}
+
+// mappingDefinesSchema is true when the property mapping gives the type of every property of the
+// queryables or the feature schema, so that no sample is needed. That is when all properties
+// listed are mapped to a type, and dates have a format telling them apart from timestamps. The
+// feature schema has all properties unless the dataset includes only some of them.
+func (ds *Dataset) mappingDefinesSchema(queryables bool) bool {
+	pm := ds.propertyMapping()
+	if len(pm.Include) == 0 && (!queryables || len(pm.Mappings) == 0) {
+		return false
+	}
+	for _, m := range pm.Mappings {
+		if m.Type == "" || m.Type == "date" && m.Format == "" {
+			return false
+		}
+	}
+	for _, name := range pm.Include {
+		if pm.lookup(name) == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// sampleFeatures returns the first features of the dataset that property types are inferred
+// from. The sample is kept on the dataset and read again when it is older than the refresh
+// interval of the entity cache.
+func (ds *Dataset) sampleFeatures(baseUrl string) ([]*Feature, error) {
+	ds.schemaSampleMu.Lock()
+	defer ds.schemaSampleMu.Unlock()
+	if ds.schemaSample != nil && time.Since(ds.schemaSampled) <= entityCacheRefreshInterval {
+		return ds.schemaSample, nil
+	}
+	page, err := readItems(ds, baseUrl, &itemsCursor{}, schemaSampleSize, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+	ds.schemaSample, ds.schemaSampled = page.Features, time.Now()
+	return ds.schemaSample, nil
+}
+
+// makeFeatureSchema describes the properties of the features of the dataset. The queryables
+// have the properties filters accept, along with geometry, datetime and id, and the feature
+// schema has all properties with the roles of the id, geometry and time properties.
+func makeFeatureSchema(ds *Dataset, sample []*Feature, queryables bool) *JSONSchema {
+	title := ds.Title
+	if title == "" {
+		title = ds.Name
+	}
+	schema := &JSONSchema{
+		Schema:     jsonSchemaDialect,
+		Type:       "object",
+		Title:      title,
+		Properties: make(map[string]*JSONSchema),
+	}
+
+	for _, f := range sample {
+		for k, v := range f.Properties {
+			schema.Properties[k] = mergeSchemas(schema.Properties[k], inferSchema(v))
+		}
+	}
+	for k, s := range schema.Properties {
+		if s == nil {
+			// only null values were seen
+			schema.Properties[k] = &JSONSchema{}
+		}
+	}
+	for _, m := range ds.propertyMapping().Mappings {
+		key := schemaKey(schema.Properties, m)
+		sampled := schema.Properties[key]
+		if m.Type != "" || sampled == nil {
+			schema.Properties[key] = mappedSchema(m)
+		}
+		if m.Type == "date" && m.Format == "" && sampled != nil && sampled.Format != "" {
+			// dates and timestamps are told apart by the values
+			schema.Properties[key].Format = sampled.Format
+		}
+	}
+
+	for i, tp := range ds.TimeProperties {
+		key := schemaKey(schema.Properties, &PropertyMap{Property: tp.Property})
+		if s, found := schema.Properties[key]; found && !queryables {
+			if s.Type == "string" && tp.Format != "" {
+				s.Format = "date"
+				if hasClock(tp.Format, nil) {
+					s.Format = "date-time"
+				}
+			}
+			s.Role = "primary-instant"
+			if len(ds.TimeProperties) == 2 {
+				s.Role = []string{"primary-interval-start", "primary-interval-end"}[i]
+			}
+		}
+	}
+
+	geometry := &JSONSchema{Title: "The geometry of the feature", Format: geometryFormat(sample)}
+	id := &JSONSchema{Title: "The id of the feature", Type: "string"}
+	if queryables {
+		for k := range schema.Properties {
+			if !isSchemaQueryable(ds, k) {
+				delete(schema.Properties, k)
+			}
+		}
+		schema.Properties["datetime"] = &JSONSchema{
+			Title:  "The time of the feature, or the time it was recorded when it has none",
+			Type:   "string",
+			Format: "date-time",
+		}
+		// without a mapping the properties found in entities read later are queryable too
+		additional := ds.queryables() == nil
+		schema.AdditionalProperties = &additional
+	} else {
+		geometry.Role = "primary-geometry"
+		id.Role = "id"
+	}
+	schema.Properties["geometry"] = geometry
+	schema.Properties["id"] = id
+	return schema
+}
+
+// schemaKey finds the key of a mapped property among the sampled properties, which depends on
+// the propertyKeys setting, or names it as the mapping does when it is not in the sample
+func schemaKey(properties map[string]*JSONSchema, m *PropertyMap) string {
+	if m.Name != "" {
+		return m.Name
+	}
+	keys := make([]string, 0, len(properties))
+	for k := range properties {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if matchesProperty(k, m.Property) || strings.HasSuffix(k, ":"+m.Property) {
+			return k
+		}
+	}
+	return m.Property
+}
+
+// isSchemaQueryable is true when filters accept the property key by itself or by its local name
+func isSchemaQueryable(ds *Dataset, key string) bool {
+	if _, queryable := ds.lookupQueryable(key); queryable {
+		return true
+	}
+	local := stripUrl(key)
+	if isCURIE, _, name := isCURIE(key); isCURIE {
+		local = name
+	}
+	_, queryable := ds.lookupQueryable(local)
+	return queryable
+}
+
+// mappedSchema is the schema of a property with the type given by its mapping
+func mappedSchema(m *PropertyMap) *JSONSchema {
+	s := &JSONSchema{}
+	if m.Name != "" {
+		s.Title = m.Property
+	}
+	switch m.Type {
+	case "string":
+		s.Type = "string"
+	case "int":
+		s.Type = "integer"
+	case "float":
+		s.Type = "number"
+	case "bool":
+		s.Type = "boolean"
+	case "date":
+		s.Type = "string"
+		s.Format = "date"
+		if hasClock(m.Format, nil) {
+			s.Format = "date-time"
+		}
+	}
+	return s
+}
+
+// inferSchema is the schema of a property value. Strings holding RFC 3339 times or dates get
+// the date-time or date format.
+func inferSchema(v any) *JSONSchema {
+	switch value := v.(type) {
+	case float64:
+		return &JSONSchema{Type: "number"}
+	case int64:
+		return &JSONSchema{Type: "integer"}
+	case bool:
+		return &JSONSchema{Type: "boolean"}
+	case string:
+		s := &JSONSchema{Type: "string"}
+		if _, _, err := parseDatetimeBound(value); err == nil {
+			s.Format = "date-time"
+			if len(strings.TrimSpace(value)) == len("2006-01-02") {
+				s.Format = "date"
+			}
+		}
+		return s
+	case []string:
+		return &JSONSchema{Type: "array", Items: &JSONSchema{Type: "string"}}
+	case []any:
+		s := &JSONSchema{Type: "array"}
+		for _, item := range value {
+			s.Items = mergeSchemas(s.Items, inferSchema(item))
+		}
+		return s
+	case map[string]any:
+		s := &JSONSchema{Type: "object", Properties: make(map[string]*JSONSchema)}
+		for k, item := range value {
+			s.Properties[k] = mergeSchemas(s.Properties[k], inferSchema(item))
+		}
+		return s
+	}
+	return nil
+}
+
+// mergeSchemas joins the schemas of two values of the same property. Integers and numbers make
+// numbers, and values of different types leave the type open.
+func mergeSchemas(a *JSONSchema, b *JSONSchema) *JSONSchema {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+	merged := *a
+	if a.Type != b.Type {
+		if (a.Type == "integer" || a.Type == "number") && (b.Type == "integer" || b.Type == "number") {
+			merged.Type = "number"
+		} else {
+			return &JSONSchema{Title: a.Title}
+		}
+	}
+	if a.Format != b.Format {
+		merged.Format = ""
+	}
+	merged.Items = mergeSchemas(a.Items, b.Items)
+	if a.Properties != nil || b.Properties != nil {
+		merged.Properties = make(map[string]*JSONSchema)
+		for k, s := range a.Properties {
+			merged.Properties[k] = s
+		}
+		for k, s := range b.Properties {
+			merged.Properties[k] = mergeSchemas(merged.Properties[k], s)
+		}
+	}
+	return &merged
+}
+
+// geometryFormat is the format of the geometries of the sample, as in Part 5, e.g.
+// geometry-point, or geometry-any when they have several types or there are none
+func geometryFormat(sample []*Feature) string {
+	types := make(map[string]bool)
+	for _, f := range sample {
+		if f.Geometry != nil {
+			types[f.Geometry.Type] = true
+		}
+	}
+	if len(types) != 1 {
+		return "geometry-any"
+	}
+	for t := range types {
+		return "geometry-" + strings.ToLower(t)
+	}
+	return "geometry-any"
+}
+
+// schemaLinks are the links from a collection to its queryables and its schema
+func schemaLinks(collectionUrl string) []*Link {
+	return []*Link{
+		{Href: collectionUrl + "/queryables", Rel: relQueryables, Type: mediaTypeSchema, Title: "The properties that can be filtered on"},
+		{Href: collectionUrl + "/schema", Rel: relSchema, Type: mediaTypeSchema, Title: "The schema of the features"},
+	}
+}
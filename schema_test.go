@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestGetSchema(t *testing.T) {
+	hub := &fakeDatahub{datasets: map[string][]string{
+		"schema sample": {
+			`{"http://data.mimiro.io/models/flatgeo/wkt":"POINT (10 60)","http://data.example.org/Quantity":1,"http://data.example.org/Name":"a","http://data.example.org/Observed":"2011-07-01"}`,
+			`{"http://data.mimiro.io/models/flatgeo/wkt":"POINT (11 60)","http://data.example.org/Quantity":2.5,"http://data.example.org/Name":"b"}`,
+		},
+	}}
+	serveDatahub(t, hub)
+	sampled := &Dataset{Name: "sampled", Type: "features", RemoteDataset: "schema sample", StripPropertyUrls: true}
+	// the UDA endpoint does not have the dataset, so reading a sample would fail
+	typed := &Dataset{Name: "typed", Type: "features", RemoteDataset: "schema typed", StripPropertyUrls: true,
+		PropertyMapping: &PropertyMapping{
+			Include: []string{"Quantity"},
+			Mappings: []*PropertyMap{
+				{Property: "Quantity", Type: "int"},
+				{Property: "Observed", Name: "observed", Type: "date", Format: "date"},
+			},
+		}}
+	RemoteDatahub.Datasets = []*Dataset{sampled, typed}
+	get := func(collection string, queryables bool) *JSONSchema {
+		req := httptest.NewRequest(http.MethodGet, "/collections/"+collection+"/schema", nil)
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(req, rec)
+		c.SetParamNames("collection")
+		c.SetParamValues(collection)
+		handler := getFeatureSchema
+		if queryables {
+			handler = getQueryables
+		}
+		if err := handler(c); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: got %d %s", collection, rec.Code, rec.Body.String())
+		}
+		var schema JSONSchema
+		if err := json.Unmarshal(rec.Body.Bytes(), &schema); err != nil {
+			t.Fatal(err)
+		}
+		return &schema
+	}
+	type expected struct {
+		name   string
+		type_  string
+		format string
+		role   string
+	}
+	check := func(title string, schema *JSONSchema, properties []expected) {
+		if len(schema.Properties) != len(properties) {
+			t.Errorf("%s: got %d properties, expected %d", title, len(schema.Properties), len(properties))
+		}
+		for _, p := range properties {
+			s, found := schema.Properties[p.name]
+			if !found {
+				t.Errorf("%s: %s is missing", title, p.name)
+				continue
+			}
+			if s.Type != p.type_ || s.Format != p.format || s.Role != p.role {
+				t.Errorf("%s: got %s %+v, expected %+v", title, p.name, s, p)
+			}
+		}
+	}
+
+	// types of properties without a mapping come from the sample
+	check("sampled schema", get("sampled", false), []expected{
+		{name: "Quantity", type_: "number"},
+		{name: "Name", type_: "string"},
+		{name: "Observed", type_: "string", format: "date"},
+		{name: "wkt", type_: "string"},
+		{name: "geometry", format: "geometry-point", role: "primary-geometry"},
+		{name: "id", type_: "string", role: "id"},
+	})
+	queryables := get("sampled", true)
+	check("sampled queryables", queryables, []expected{
+		{name: "Quantity", type_: "number"},
+		{name: "Name", type_: "string"},
+		{name: "Observed", type_: "string", format: "date"},
+		{name: "wkt", type_: "string"},
+		{name: "datetime", type_: "string", format: "date-time"},
+		{name: "geometry", format: "geometry-point"},
+		{name: "id", type_: "string"},
+	})
+	if queryables.AdditionalProperties == nil || !*queryables.AdditionalProperties {
+		t.Errorf("the queryables of a dataset without a mapping should allow additional properties")
+	}
+
+	// a mapping typing every property is enough, and no sample is read
+	requests := atomic.LoadInt32(&hub.requests)
+	check("typed schema", get("typed", false), []expected{
+		{name: "Quantity", type_: "integer"},
+		{name: "observed", type_: "string", format: "date"},
+		{name: "geometry", format: "geometry-any", role: "primary-geometry"},
+		{name: "id", type_: "string", role: "id"},
+	})
+	queryables = get("typed", true)
+	check("typed queryables", queryables, []expected{
+		{name: "Quantity", type_: "integer"},
+		{name: "observed", type_: "string", format: "date"},
+		{name: "datetime", type_: "string", format: "date-time"},
+		{name: "geometry", format: "geometry-any"},
+		{name: "id", type_: "string"},
+	})
+	if queryables.AdditionalProperties == nil || *queryables.AdditionalProperties {
+		t.Errorf("the queryables of a mapped dataset should not allow additional properties")
+	}
+	if extra := atomic.LoadInt32(&hub.requests) - requests; extra != 0 {
+		t.Errorf("the typed dataset was sampled with %d requests", extra)
+	}
+}
+
+func TestMappingDefinesSchema(t *testing.T) {
+	tests := []struct {
+		name       string
+		mapping    *PropertyMapping
+		schema     bool
+		queryables bool
+	}{
+		{name: "no mapping"},
+		{
+			name:       "typed mappings of all properties",
+			mapping:    &PropertyMapping{Mappings: []*PropertyMap{{Property: "Quantity", Type: "int"}}},
+			queryables: true,
+		},
+		{
+			name:       "typed mappings of the included properties",
+			mapping:    &PropertyMapping{Include: []string{"Quantity"}, Mappings: []*PropertyMap{{Property: "Quantity", Type: "int"}}},
+			schema:     true,
+			queryables: true,
+		},
+		{
+			name:    "an included property without a mapping",
+			mapping: &PropertyMapping{Include: []string{"Quantity", "Name"}, Mappings: []*PropertyMap{{Property: "Quantity", Type: "int"}}},
+		},
+		{
+			name:    "a mapping without a type",
+			mapping: &PropertyMapping{Include: []string{"Quantity"}, Mappings: []*PropertyMap{{Property: "Quantity", Name: "quantity"}}},
+		},
+		{
+			name:    "a date without a format",
+			mapping: &PropertyMapping{Include: []string{"Observed"}, Mappings: []*PropertyMap{{Property: "Observed", Type: "date"}}},
+		},
+	}
+	for _, test := range tests {
+		ds := &Dataset{Name: "test", PropertyMapping: test.mapping}
+		if schema := ds.mappingDefinesSchema(false); schema != test.schema {
+			t.Errorf("%s: the mapping defines the schema: %v", test.name, schema)
+		}
+		if queryables := ds.mappingDefinesSchema(true); queryables != test.queryables {
+			t.Errorf("%s: the mapping defines the queryables: %v", test.name, queryables)
+		}
+	}
+}
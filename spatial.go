@@ -0,0 +1,234 @@
+package main
+
+// Spatial relations between geometries, for the spatial functions of CQL2 filters. Geometries
+// are compared in the plane of their longitude and latitude.
+
+// geometryParts are the points, lines and polygons of a geometry, with collections flattened,
+// along with their segments, vertices and polygon boundaries. They are computed once per
+// geometry, so that a geometry literal of a filter is decomposed when the filter is parsed.
+type geometryParts struct {
+	points   [][]float64
+	lines    [][][]float64
+	polygons [][][][]float64
+
+	// segments are all segments of the parts, with points as segments of length zero
+	segments [][2][]float64
+	// vertices are all positions of the parts
+	vertices [][]float64
+	// boundaries are the segments of the rings of the polygons
+	boundaries [][2][]float64
+}
+
+func decomposeGeometry(g *Geometry) *geometryParts {
+	parts := &geometryParts{}
+	parts.add(g)
+	parts.segments = parts.makeSegments()
+	parts.vertices = parts.makeVertices()
+	for _, polygon := range parts.polygons {
+		for _, ring := range polygon {
+			for i := 1; i < len(ring); i++ {
+				parts.boundaries = append(parts.boundaries, [2][]float64{ring[i-1], ring[i]})
+			}
+		}
+	}
+	return parts
+}
+
+func (parts *geometryParts) add(g *Geometry) {
+	if g == nil {
+		return
+	}
+	switch g.Type {
+	case "GeometryCollection":
+		for _, member := range g.Geometries {
+			parts.add(member)
+		}
+	case "Point":
+		if p := pointPosition(g); len(p) >= 2 {
+			parts.points = append(parts.points, p)
+		}
+	case "MultiPoint":
+		parts.points = append(parts.points, linePositions(g)...)
+	case "LineString":
+		parts.lines = append(parts.lines, linePositions(g))
+	case "MultiLineString", "Polygon":
+		lines := make([][][]float64, 0, len(g.Coordinates))
+		for _, c := range g.Coordinates {
+			if line, ok := c.([][]float64); ok {
+				lines = append(lines, line)
+			}
+		}
+		if g.Type == "Polygon" {
+			if len(lines) > 0 {
+				parts.polygons = append(parts.polygons, lines)
+			}
+		} else {
+			parts.lines = append(parts.lines, lines...)
+		}
+	case "MultiPolygon":
+		for _, c := range g.Coordinates {
+			if rings, ok := c.([][][]float64); ok && len(rings) > 0 {
+				parts.polygons = append(parts.polygons, rings)
+			}
+		}
+	}
+}
+
+func (parts *geometryParts) makeSegments() [][2][]float64 {
+	segments := make([][2][]float64, 0)
+	for _, p := range parts.points {
+		segments = append(segments, [2][]float64{p, p})
+	}
+	addPath := func(path [][]float64) {
+		if len(path) == 1 {
+			segments = append(segments, [2][]float64{path[0], path[0]})
+		}
+		for i := 1; i < len(path); i++ {
+			segments = append(segments, [2][]float64{path[i-1], path[i]})
+		}
+	}
+	for _, line := range parts.lines {
+		addPath(line)
+	}
+	for _, polygon := range parts.polygons {
+		for _, ring := range polygon {
+			addPath(ring)
+		}
+	}
+	return segments
+}
+
+func (parts *geometryParts) makeVertices() [][]float64 {
+	vertices := append([][]float64{}, parts.points...)
+	for _, line := range parts.lines {
+		vertices = append(vertices, line...)
+	}
+	for _, polygon := range parts.polygons {
+		for _, ring := range polygon {
+			vertices = append(vertices, ring...)
+		}
+	}
+	return vertices
+}
+
+func (parts *geometryParts) isEmpty() bool {
+	return len(parts.points) == 0 && len(parts.lines) == 0 && len(parts.polygons) == 0
+}
+
+// containsPoint is true when the point lies on the parts or inside one of their polygons
+func (parts *geometryParts) containsPoint(p []float64) bool {
+	for _, s := range parts.segments {
+		if segmentsIntersect(s[0], s[1], p, p) {
+			return true
+		}
+	}
+	for _, polygon := range parts.polygons {
+		if pointInPolygon(p, polygon) {
+			return true
+		}
+	}
+	return false
+}
+
+// pointInPolygon is true when the point is inside the exterior ring and outside the holes
+func pointInPolygon(p []float64, rings [][][]float64) bool {
+	if len(rings) == 0 || !pointInRing(p, rings[0]) {
+		return false
+	}
+	for _, hole := range rings[1:] {
+		if pointInRing(p, hole) {
+			return false
+		}
+	}
+	return true
+}
+
+// partsIntersect is true when the geometries share at least one point: when their boundaries,
+// lines or points meet, or when one lies inside a polygon of the other
+func partsIntersect(pa *geometryParts, pb *geometryParts) bool {
+	if pa.isEmpty() || pb.isEmpty() {
+		return false
+	}
+	for _, s := range pa.segments {
+		for _, t := range pb.segments {
+			if segmentsIntersect(s[0], s[1], t[0], t[1]) {
+				return true
+			}
+		}
+	}
+	for _, polygon := range pb.polygons {
+		for _, v := range pa.vertices {
+			if pointInPolygon(v, polygon) {
+				return true
+			}
+		}
+	}
+	for _, polygon := range pa.polygons {
+		for _, v := range pb.vertices {
+			if pointInPolygon(v, polygon) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// partsWithin is true when every point of a is also a point of b. All vertices of a and the
+// midpoints of its segments must lie in b, no segment of a may cross the boundary of a polygon
+// of b, and no hole of b may lie inside a.
+func partsWithin(pa *geometryParts, pb *geometryParts) bool {
+	if pa.isEmpty() || pb.isEmpty() {
+		return false
+	}
+	for _, v := range pa.vertices {
+		if !pb.containsPoint(v) {
+			return false
+		}
+	}
+
+	for _, s := range pa.segments {
+		midpoint := []float64{(s[0][0] + s[1][0]) / 2, (s[0][1] + s[1][1]) / 2}
+		if !pb.containsPoint(midpoint) {
+			return false
+		}
+		for _, t := range pb.boundaries {
+			if segmentsCross(s[0], s[1], t[0], t[1]) {
+				return false
+			}
+		}
+	}
+
+	for _, polygon := range pb.polygons {
+		for _, hole := range polygon[1:] {
+			for _, v := range hole {
+				for _, outer := range pa.polygons {
+					if pointInPolygon(v, outer) && !onRings(v, outer) {
+						return false
+					}
+				}
+			}
+		}
+	}
+	return true
+}
+
+// onRings is true when the point lies on one of the rings
+func onRings(p []float64, rings [][][]float64) bool {
+	for _, ring := range rings {
+		for i := 1; i < len(ring); i++ {
+			if segmentsIntersect(ring[i-1], ring[i], p, p) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// segmentsCross is true when the segments ab and cd cross each other at a point inside both
+func segmentsCross(a, b, c, d []float64) bool {
+	d1 := orientation(c, d, a)
+	d2 := orientation(c, d, b)
+	d3 := orientation(a, b, c)
+	d4 := orientation(a, b, d)
+	return ((d1 > 0 && d2 < 0) || (d1 < 0 && d2 > 0)) && ((d3 > 0 && d4 < 0) || (d3 < 0 && d4 > 0))
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TimeProperty names a property holding a time and the format it is written in. The format is
+// a Go time layout, such as 02/01/2006, or one of the names in namedTimeFormats.
+type TimeProperty struct {
+	Property string `json:"property"`
+	Format   string `json:"format,omitempty"`
+}
+
+// namedTimeFormats are the formats that can be given by name instead of by layout. An empty
+// format accepts RFC 3339 timestamps and dates.
+var namedTimeFormats = map[string]string{
+	"rfc3339":  time.RFC3339Nano,
+	"date":     "2006-01-02",
+	"datetime": "2006-01-02T15:04:05",
+}
+
+// FeatureTime is the temporal member of a feature, as in OGC Features and Geometries JSON. It
+// holds a date or a timestamp for an instant, or an interval where ".." marks an open end.
+type FeatureTime struct {
+	Date      string   `json:"date,omitempty"`
+	Timestamp string   `json:"timestamp,omitempty"`
+	Interval  []string `json:"interval,omitempty"`
+}
+
+// makeFeatureTime builds the time of a feature from the time properties configured on the
+// dataset. One property gives an instant, two give the start and end of an interval. Next to
+// the temporal member it returns the covered time range, where a zero time is an open end. It
+// returns nil when the dataset has no time properties or the entity has none of them.
+func makeFeatureTime(e *Entity, ds *Dataset) (*FeatureTime, time.Time, time.Time, error) {
+	var start, end time.Time
+	if len(ds.TimeProperties) == 0 {
+		return nil, start, end, nil
+	}
+
+	values := make([]time.Time, len(ds.TimeProperties))
+	dateOnly := true
+	found := false
+	for i, tp := range ds.TimeProperties {
+		value, ok := e.findPropertyValue(tp.Property)
+		if !ok || value == nil {
+			continue
+		}
+		t, err := parseTime(value, tp.Format)
+		if err != nil {
+			return nil, start, end, fmt.Errorf("invalid time in %s: %w", tp.Property, err)
+		}
+		values[i] = t
+		found = true
+		dateOnly = dateOnly && !hasClock(tp.Format, value)
+	}
+	if !found {
+		return nil, start, end, nil
+	}
+
+	format := func(t time.Time) string {
+		if t.IsZero() {
+			return ".."
+		}
+		if dateOnly {
+			return t.Format("2006-01-02")
+		}
+		return t.Format(time.RFC3339Nano)
+	}
+
+	ft := &FeatureTime{}
+	if len(values) == 1 {
+		start, end = values[0], values[0]
+		if dateOnly {
+			ft.Date = format(start)
+			// a date covers the whole day
+			end = start.AddDate(0, 0, 1).Add(-time.Nanosecond)
+		} else {
+			ft.Timestamp = format(start)
+		}
+	} else {
+		// a zero start or end is an open end of the interval
+		start, end = values[0], values[1]
+		ft.Interval = []string{format(start), format(end)}
+		if dateOnly && !end.IsZero() {
+			end = end.AddDate(0, 0, 1).Add(-time.Nanosecond)
+		}
+	}
+	return ft, start, end, nil
+}
+
+// parseTime reads a time value with a Go layout or a named format. Times without a zone are
+// taken to be UTC. Single valued lists are unwrapped.
+func parseTime(value any, format string) (time.Time, error) {
+	if list, ok := value.([]any); ok && len(list) == 1 {
+		value = list[0]
+	}
+
+	switch strings.ToLower(format) {
+	case "unix", "unixms":
+		seconds, err := toNumber(value)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if strings.ToLower(format) == "unixms" {
+			return time.UnixMilli(int64(seconds)).UTC(), nil
+		}
+		whole, fraction := math.Modf(seconds)
+		return time.Unix(int64(whole), int64(fraction*1e9)).UTC(), nil
+	}
+
+	var s string
+	switch v := value.(type) {
+	case string:
+		s = strings.TrimSpace(v)
+	case float64:
+		// e.g. a year stored as a number
+		s = strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return time.Time{}, fmt.Errorf("not a time: %v", value)
+	}
+
+	if format == "" {
+		for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02"} {
+			if t, err := time.ParseInLocation(layout, s, time.UTC); err == nil {
+				return t.UTC(), nil
+			}
+		}
+		return time.Time{}, errors.New("not an RFC 3339 time: " + s)
+	}
+
+	layout := format
+	if named, ok := namedTimeFormats[strings.ToLower(format)]; ok {
+		layout = named
+	}
+	t, err := time.ParseInLocation(layout, s, time.UTC)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s does not match format %s", s, format)
+	}
+	return t.UTC(), nil
+}
+
+// hasClock is true for values of the format that include a time of day. Without a format it
+// depends on the value, as both RFC 3339 timestamps and dates are accepted. A nil value then
+// counts as a timestamp.
+func hasClock(format string, value any) bool {
+	switch strings.ToLower(format) {
+	case "":
+		if list, ok := value.([]any); ok && len(list) == 1 {
+			value = list[0]
+		}
+		s, ok := value.(string)
+		return !ok || len(strings.TrimSpace(s)) != len("2006-01-02")
+	case "rfc3339", "datetime", "unix", "unixms":
+		return true
+	case "date":
+		return false
+	}
+	return strings.Contains(format, "15") || strings.Contains(format, "03") ||
+		strings.Contains(format, "3:04") || strings.Contains(format, "04")
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseTime(t *testing.T) {
+	tests := []struct {
+		value  any
+		format string
+		time   time.Time
+	}{
+		{value: "2011-07-01T09:00:00Z", time: time.Date(2011, 7, 1, 9, 0, 0, 0, time.UTC)},
+		{value: "2011-07-01T11:00:00+02:00", time: time.Date(2011, 7, 1, 9, 0, 0, 0, time.UTC)},
+		{value: "2011-07-01T09:00:00.5Z", time: time.Date(2011, 7, 1, 9, 0, 0, 5e8, time.UTC)},
+		{value: "2011-07-01T09:00:00", time: time.Date(2011, 7, 1, 9, 0, 0, 0, time.UTC)},
+		{value: " 2011-07-01 ", time: time.Date(2011, 7, 1, 0, 0, 0, 0, time.UTC)},
+		{value: []any{"2011-07-01"}, time: time.Date(2011, 7, 1, 0, 0, 0, 0, time.UTC)},
+		{value: "2011-07-01T09:00:00Z", format: "rfc3339", time: time.Date(2011, 7, 1, 9, 0, 0, 0, time.UTC)},
+		{value: "2011-07-01", format: "date", time: time.Date(2011, 7, 1, 0, 0, 0, 0, time.UTC)},
+		{value: "2011-07-01T09:00:00", format: "DateTime", time: time.Date(2011, 7, 1, 9, 0, 0, 0, time.UTC)},
+		{value: "01/07/2011", format: "02/01/2006", time: time.Date(2011, 7, 1, 0, 0, 0, 0, time.UTC)},
+		{value: 2011.0, format: "2006", time: time.Date(2011, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{value: 1309510800.0, format: "unix", time: time.Date(2011, 7, 1, 9, 0, 0, 0, time.UTC)},
+		{value: 1309510800.25, format: "unix", time: time.Date(2011, 7, 1, 9, 0, 0, 25e7, time.UTC)},
+		{value: "1309510800", format: "unix", time: time.Date(2011, 7, 1, 9, 0, 0, 0, time.UTC)},
+		{value: 1309510800250.0, format: "unixms", time: time.Date(2011, 7, 1, 9, 0, 0, 25e7, time.UTC)},
+	}
+	for _, test := range tests {
+		parsed, err := parseTime(test.value, test.format)
+		if err != nil {
+			t.Errorf("%v as %q: %v", test.value, test.format, err)
+			continue
+		}
+		if !parsed.Equal(test.time) || parsed.Location() != time.UTC {
+			t.Errorf("%v as %q: got %v, expected %v", test.value, test.format, parsed, test.time)
+		}
+	}
+
+	invalid := []struct {
+		value  any
+		format string
+	}{
+		{value: "01/07/2011"},
+		{value: "2011-07-01", format: "02/01/2006"},
+		{value: "2011-07-01T09:00:00Z", format: "date"},
+		{value: "yesterday", format: "unix"},
+		{value: true},
+		{value: []any{"2011-07-01", "2011-07-02"}},
+	}
+	for _, test := range invalid {
+		if _, err := parseTime(test.value, test.format); err == nil {
+			t.Errorf("%v as %q: expected an error", test.value, test.format)
+		}
+	}
+}
+
+func TestMakeFeatureTime(t *testing.T) {
+	day := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+	}
+	endOfDay := func(year int, month time.Month, d int) time.Time {
+		return day(year, month, d).AddDate(0, 0, 1).Add(-time.Nanosecond)
+	}
+	tests := []struct {
+		name       string
+		properties []*TimeProperty
+		values     map[string]any
+		time       *FeatureTime
+		start      time.Time
+		end        time.Time
+	}{
+		{
+			name:       "a timestamp",
+			properties: []*TimeProperty{{Property: "Observed"}},
+			values:     map[string]any{"Observed": "2011-07-01T09:00:00Z"},
+			time:       &FeatureTime{Timestamp: "2011-07-01T09:00:00Z"},
+			start:      time.Date(2011, 7, 1, 9, 0, 0, 0, time.UTC),
+			end:        time.Date(2011, 7, 1, 9, 0, 0, 0, time.UTC),
+		},
+		{
+			name:       "a date without a format covers the whole day",
+			properties: []*TimeProperty{{Property: "Observed"}},
+			values:     map[string]any{"Observed": "2011-07-01"},
+			time:       &FeatureTime{Date: "2011-07-01"},
+			start:      day(2011, 7, 1),
+			end:        endOfDay(2011, 7, 1),
+		},
+		{
+			name:       "a date layout covers the whole day",
+			properties: []*TimeProperty{{Property: "Observed", Format: "02/01/2006"}},
+			values:     map[string]any{"Observed": "01/07/2011"},
+			time:       &FeatureTime{Date: "2011-07-01"},
+			start:      day(2011, 7, 1),
+			end:        endOfDay(2011, 7, 1),
+		},
+		{
+			name:       "a layout with a clock gives a timestamp",
+			properties: []*TimeProperty{{Property: "Observed", Format: "02/01/2006 15:04"}},
+			values:     map[string]any{"Observed": "01/07/2011 09:30"},
+			time:       &FeatureTime{Timestamp: "2011-07-01T09:30:00Z"},
+			start:      time.Date(2011, 7, 1, 9, 30, 0, 0, time.UTC),
+			end:        time.Date(2011, 7, 1, 9, 30, 0, 0, time.UTC),
+		},
+		{
+			name:       "unix seconds give a timestamp",
+			properties: []*TimeProperty{{Property: "Observed", Format: "unix"}},
+			values:     map[string]any{"Observed": 1309510800.0},
+			time:       &FeatureTime{Timestamp: "2011-07-01T09:00:00Z"},
+			start:      time.Date(2011, 7, 1, 9, 0, 0, 0, time.UTC),
+			end:        time.Date(2011, 7, 1, 9, 0, 0, 0, time.UTC),
+		},
+		{
+			name:       "an interval of dates ends at the end of the last day",
+			properties: []*TimeProperty{{Property: "From", Format: "date"}, {Property: "To", Format: "date"}},
+			values:     map[string]any{"From": "2011-07-01", "To": "2011-07-31"},
+			time:       &FeatureTime{Interval: []string{"2011-07-01", "2011-07-31"}},
+			start:      day(2011, 7, 1),
+			end:        endOfDay(2011, 7, 31),
+		},
+		{
+			name:       "an interval of timestamps",
+			properties: []*TimeProperty{{Property: "From"}, {Property: "To"}},
+			values:     map[string]any{"From": "2011-07-01T09:00:00Z", "To": "2011-07-01T17:00:00Z"},
+			time:       &FeatureTime{Interval: []string{"2011-07-01T09:00:00Z", "2011-07-01T17:00:00Z"}},
+			start:      time.Date(2011, 7, 1, 9, 0, 0, 0, time.UTC),
+			end:        time.Date(2011, 7, 1, 17, 0, 0, 0, time.UTC),
+		},
+		{
+			name:       "a date and a timestamp give an interval of timestamps",
+			properties: []*TimeProperty{{Property: "From"}, {Property: "To"}},
+			values:     map[string]any{"From": "2011-07-01", "To": "2011-07-01T17:00:00Z"},
+			time:       &FeatureTime{Interval: []string{"2011-07-01T00:00:00Z", "2011-07-01T17:00:00Z"}},
+			start:      day(2011, 7, 1),
+			end:        time.Date(2011, 7, 1, 17, 0, 0, 0, time.UTC),
+		},
+		{
+			name:       "an interval without an end is open",
+			properties: []*TimeProperty{{Property: "From"}, {Property: "To"}},
+			values:     map[string]any{"From": "2011-07-01"},
+			time:       &FeatureTime{Interval: []string{"2011-07-01", ".."}},
+			start:      day(2011, 7, 1),
+		},
+		{
+			name:       "an interval without a start is open",
+			properties: []*TimeProperty{{Property: "From"}, {Property: "To"}},
+			values:     map[string]any{"To": "2011-07-31"},
+			time:       &FeatureTime{Interval: []string{"..", "2011-07-31"}},
+			end:        endOfDay(2011, 7, 31),
+		},
+		{
+			name:       "an entity without the time properties has no time",
+			properties: []*TimeProperty{{Property: "Observed"}},
+			values:     map[string]any{"Name": "a"},
+		},
+		{
+			name:   "a dataset without time properties has no time",
+			values: map[string]any{"Observed": "2011-07-01"},
+		},
+	}
+	for _, test := range tests {
+		ds := &Dataset{Name: "test", TimeProperties: test.properties}
+		ft, start, end, err := makeFeatureTime(testEntity("1", test.values), ds)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(ft, test.time) {
+			t.Errorf("%s: got time %+v, expected %+v", test.name, ft, test.time)
+		}
+		if !start.Equal(test.start) || !end.Equal(test.end) {
+			t.Errorf("%s: got range %v to %v, expected %v to %v", test.name, start, end, test.start, test.end)
+		}
+	}
+
+	ds := &Dataset{Name: "test", TimeProperties: []*TimeProperty{{Property: "Observed", Format: "date"}}}
+	if _, _, _, err := makeFeatureTime(testEntity("1", map[string]any{"Observed": "01/07/2011"}), ds); err == nil {
+		t.Errorf("a value not matching the format should be an error")
+	}
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+const (
+	ewkbZFlag    = 0x80000000
+	ewkbMFlag    = 0x40000000
+	ewkbSRIDFlag = 0x20000000
+
+	// wkbMinMemberSize is the size of the smallest member of a multi geometry or collection, an
+	// empty line string: byte order, type and count
+	wkbMinMemberSize = 9
+)
+
+// wkbGeometryTypes maps the WKB type codes to GeoJSON geometry types. As for WKT, triangles,
+// polyhedral surfaces and TINs become polygons and multi polygons.
+var wkbGeometryTypes = map[uint32]string{
+	1:  "Point",
+	2:  "LineString",
+	3:  "Polygon",
+	4:  "MultiPoint",
+	5:  "MultiLineString",
+	6:  "MultiPolygon",
+	7:  "GeometryCollection",
+	15: "MultiPolygon",
+	16: "MultiPolygon",
+	17: "Polygon",
+}
+
+// parseWKBHex parses a hex encoded WKB or EWKB geometry, as produced by PostGIS. Both the ISO
+// (1000, 2000 and 3000 offsets) and the EWKB (high bit flags) ways of marking Z and M are
+// understood. The SRID of an EWKB geometry is kept on the geometry; M values are dropped.
+func parseWKBHex(value string) (*Geometry, error) {
+	value = strings.TrimSpace(value)
+	value = strings.TrimPrefix(value, "\\x")
+	value = strings.TrimPrefix(value, "0x")
+	data, err := hex.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("wkb: invalid hex: %w", err)
+	}
+	r := &wkbReader{data: data}
+	g, err := r.readGeometry()
+	if err != nil {
+		return nil, err
+	}
+	if r.pos != len(r.data) {
+		return nil, fmt.Errorf("wkb: %d trailing bytes after geometry", len(r.data)-r.pos)
+	}
+	return g, nil
+}
+
+type wkbReader struct {
+	data  []byte
+	pos   int
+	order binary.ByteOrder
+	// ordinates per position and whether the last one is an M value
+	dimensions int
+	hasM       bool
+}
+
+func (r *wkbReader) readBytes(n int) ([]byte, error) {
+	if r.pos+n > len(r.data) {
+		return nil, errors.New("wkb: unexpected end of data")
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b, nil
+}
+
+func (r *wkbReader) readUint32() (uint32, error) {
+	b, err := r.readBytes(4)
+	if err != nil {
+		return 0, err
+	}
+	return r.order.Uint32(b), nil
+}
+
+func (r *wkbReader) readFloat64() (float64, error) {
+	b, err := r.readBytes(8)
+	if err != nil {
+		return 0, err
+	}
+	return math.Float64frombits(r.order.Uint64(b)), nil
+}
+
+func (r *wkbReader) readGeometry() (*Geometry, error) {
+	b, err := r.readBytes(1)
+	if err != nil {
+		return nil, err
+	}
+	switch b[0] {
+	case 0:
+		r.order = binary.BigEndian
+	case 1:
+		r.order = binary.LittleEndian
+	default:
+		return nil, fmt.Errorf("wkb: invalid byte order %d", b[0])
+	}
+
+	typeCode, err := r.readUint32()
+	if err != nil {
+		return nil, err
+	}
+
+	hasZ := typeCode&ewkbZFlag != 0
+	r.hasM = typeCode&ewkbMFlag != 0
+	srid := 0
+	if typeCode&ewkbSRIDFlag != 0 {
+		s, err := r.readUint32()
+		if err != nil {
+			return nil, err
+		}
+		srid = int(s)
+	}
+	typeCode &^= ewkbZFlag | ewkbMFlag | ewkbSRIDFlag
+	switch typeCode / 1000 {
+	case 1:
+		hasZ = true
+	case 2:
+		r.hasM = true
+	case 3:
+		hasZ, r.hasM = true, true
+	}
+	typeCode %= 1000
+
+	r.dimensions = 2
+	if hasZ {
+		r.dimensions++
+	}
+	if r.hasM {
+		r.dimensions++
+	}
+
+	geometryType, known := wkbGeometryTypes[typeCode]
+	if !known {
+		return nil, fmt.Errorf("wkb: unknown geometry type %d", typeCode)
+	}
+
+	g := &Geometry{}
+	g.Type = geometryType
+	g.Coordinates = make([]interface{}, 0)
+	g.srid = srid
+
+	switch typeCode {
+	case 1:
+		position, err := r.readPosition()
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range position {
+			g.Coordinates = append(g.Coordinates, c)
+		}
+	case 2:
+		positions, err := r.readPositions()
+		if err != nil {
+			return nil, err
+		}
+		for _, position := range positions {
+			g.Coordinates = append(g.Coordinates, position)
+		}
+	case 3, 17:
+		rings, err := r.readRings()
+		if err != nil {
+			return nil, err
+		}
+		for _, ring := range rings {
+			g.Coordinates = append(g.Coordinates, ring)
+		}
+	default:
+		// multi geometries and collections hold complete WKB geometries as members
+		count, err := r.readUint32()
+		if err != nil {
+			return nil, err
+		}
+		if int(count) > (len(r.data)-r.pos)/wkbMinMemberSize {
+			return nil, errors.New("wkb: member count exceeds data")
+		}
+		if geometryType == "GeometryCollection" {
+			g.Geometries = make([]*Geometry, 0, count)
+		}
+		for i := uint32(0); i < count; i++ {
+			member, err := r.readGeometry()
+			if err != nil {
+				return nil, err
+			}
+			if geometryType == "GeometryCollection" {
+				g.Geometries = append(g.Geometries, member)
+				continue
+			}
+			if "Multi"+member.Type != geometryType {
+				return nil, fmt.Errorf("wkb: %s member of a %s", member.Type, geometryType)
+			}
+			switch member.Type {
+			case "Point":
+				if len(member.Coordinates) == 0 {
+					// GeoJSON has no empty positions, so empty points are left out
+					continue
+				}
+				position := make([]float64, 0, len(member.Coordinates))
+				for _, c := range member.Coordinates {
+					position = append(position, c.(float64))
+				}
+				g.Coordinates = append(g.Coordinates, position)
+			case "LineString":
+				line := make([][]float64, 0, len(member.Coordinates))
+				for _, c := range member.Coordinates {
+					line = append(line, c.([]float64))
+				}
+				g.Coordinates = append(g.Coordinates, line)
+			case "Polygon":
+				rings := make([][][]float64, 0, len(member.Coordinates))
+				for _, c := range member.Coordinates {
+					rings = append(rings, c.([][]float64))
+				}
+				g.Coordinates = append(g.Coordinates, rings)
+			}
+		}
+	}
+	return g, nil
+}
+
+// readPosition reads the ordinates of one position and keeps x, y and z. It returns nil for the
+// position of an empty point, which is written with NaN ordinates only. Any other ordinate that
+// is not finite is an error, as it cannot be published as GeoJSON.
+func (r *wkbReader) readPosition() ([]float64, error) {
+	position := make([]float64, 0, r.dimensions)
+	empty := true
+	for i := 0; i < r.dimensions; i++ {
+		f, err := r.readFloat64()
+		if err != nil {
+			return nil, err
+		}
+		empty = empty && math.IsNaN(f)
+		position = append(position, f)
+	}
+	if empty {
+		return nil, nil
+	}
+	for _, f := range position {
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return nil, fmt.Errorf("wkb: position %v is not finite", position)
+		}
+	}
+	if r.hasM {
+		position = position[:len(position)-1]
+	}
+	return position, nil
+}
+
+func (r *wkbReader) readPositions() ([][]float64, error) {
+	count, err := r.readUint32()
+	if err != nil {
+		return nil, err
+	}
+	if int(count) > (len(r.data)-r.pos)/(8*r.dimensions) {
+		return nil, errors.New("wkb: position count exceeds data")
+	}
+	positions := make([][]float64, 0, count)
+	for i := uint32(0); i < count; i++ {
+		position, err := r.readPosition()
+		if err != nil {
+			return nil, err
+		}
+		if position == nil {
+			return nil, errors.New("wkb: empty position in a line or ring")
+		}
+		positions = append(positions, position)
+	}
+	return positions, nil
+}
+
+func (r *wkbReader) readRings() ([][][]float64, error) {
+	count, err := r.readUint32()
+	if err != nil {
+		return nil, err
+	}
+	rings := make([][][]float64, 0)
+	for i := uint32(0); i < count; i++ {
+		ring, err := r.readPositions()
+		if err != nil {
+			return nil, err
+		}
+		ring = closeRing(ring)
+		if len(ring) < 4 {
+			return nil, fmt.Errorf("wkb: a polygon ring needs at least four positions, got %d", len(ring))
+		}
+		rings = append(rings, ring)
+	}
+	windRings(rings)
+	return rings, nil
+}
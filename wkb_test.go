@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseWKBHex(t *testing.T) {
+	tests := []struct {
+		name    string
+		wkb     string
+		geojson string
+		srid    int
+	}{
+		{
+			name:    "point",
+			wkb:     "0101000000000000000000F03F0000000000000040",
+			geojson: `{"type":"Point","coordinates":[1,2]}`,
+		},
+		{
+			name:    "big endian point",
+			wkb:     "00000000013FF00000000000004000000000000000",
+			geojson: `{"type":"Point","coordinates":[1,2]}`,
+		},
+		{
+			name:    "PostGIS bytea point",
+			wkb:     "\\x0101000000000000000000f03f0000000000000040",
+			geojson: `{"type":"Point","coordinates":[1,2]}`,
+		},
+		{
+			name:    "EWKB point with SRID",
+			wkb:     "0101000020E6100000000000000000F03F0000000000000040",
+			geojson: `{"type":"Point","coordinates":[1,2]}`,
+			srid:    4326,
+		},
+		{
+			name:    "ISO point Z",
+			wkb:     "01E9030000000000000000F03F00000000000000400000000000000840",
+			geojson: `{"type":"Point","coordinates":[1,2,3]}`,
+		},
+		{
+			name:    "EWKB point Z with SRID",
+			wkb:     "01010000A0E6100000000000000000F03F00000000000000400000000000000840",
+			geojson: `{"type":"Point","coordinates":[1,2,3]}`,
+			srid:    4326,
+		},
+		{
+			name:    "ISO point M",
+			wkb:     "01D1070000000000000000F03F00000000000000400000000000001040",
+			geojson: `{"type":"Point","coordinates":[1,2]}`,
+		},
+		{
+			name:    "ISO point ZM",
+			wkb:     "01B90B0000000000000000F03F000000000000004000000000000008400000000000001040",
+			geojson: `{"type":"Point","coordinates":[1,2,3]}`,
+		},
+		{
+			name:    "empty point",
+			wkb:     "0101000000000000000000F87F000000000000F87F",
+			geojson: `{"type":"Point","coordinates":[]}`,
+		},
+		{
+			name:    "line string",
+			wkb:     "010200000002000000000000000000F03F000000000000004000000000000008400000000000001040",
+			geojson: `{"type":"LineString","coordinates":[[1,2],[3,4]]}`,
+		},
+		{
+			name: "clockwise polygon",
+			wkb: "01030000000100000004000000000000000000000000000000000000000000000000000000000000000000F03F" +
+				"000000000000F03F000000000000F03F00000000000000000000000000000000",
+			geojson: `{"type":"Polygon","coordinates":[[[0,0],[1,1],[0,1],[0,0]]]}`,
+		},
+		{
+			name: "open ring",
+			wkb: "0103000000010000000300000000000000000000000000000000000000000000000000F03F0000000000000000" +
+				"000000000000F03F000000000000F03F",
+			geojson: `{"type":"Polygon","coordinates":[[[0,0],[1,0],[1,1],[0,0]]]}`,
+		},
+		{
+			name:    "multi point",
+			wkb:     "0104000000020000000101000000000000000000F03F0000000000000040010100000000000000000008400000000000001040",
+			geojson: `{"type":"MultiPoint","coordinates":[[1,2],[3,4]]}`,
+		},
+		{
+			name:    "multi line string with a big endian member",
+			wkb:     "0105000000010000000000000002000000023FF0000000000000400000000000000040080000000000004010000000000000",
+			geojson: `{"type":"MultiLineString","coordinates":[[[1,2],[3,4]]]}`,
+		},
+		{
+			name: "multi polygon",
+			wkb: "0106000000010000000103000000010000000400000000000000000000000000000000000000000000000000F03F" +
+				"0000000000000000000000000000F03F000000000000F03F00000000000000000000000000000000",
+			geojson: `{"type":"MultiPolygon","coordinates":[[[[0,0],[1,0],[1,1],[0,0]]]]}`,
+		},
+		{
+			name: "geometry collection",
+			wkb: "0107000000020000000101000000000000000000F03F0000000000000040010200000002000000000000000000F03F" +
+				"000000000000004000000000000008400000000000001040",
+			geojson: `{"type":"GeometryCollection","geometries":[{"type":"Point","coordinates":[1,2]},{"type":"LineString","coordinates":[[1,2],[3,4]]}]}`,
+		},
+		{
+			name:    "empty points of a multi point are left out",
+			wkb:     "0104000000020000000101000000000000000000F87F000000000000F87F0101000000000000000000F03F0000000000000040",
+			geojson: `{"type":"MultiPoint","coordinates":[[1,2]]}`,
+		},
+		{
+			name:    "empty geometry collection",
+			wkb:     "010700000000000000",
+			geojson: `{"type":"GeometryCollection","geometries":[]}`,
+		},
+		{
+			name: "triangle",
+			wkb: "0111000000010000000400000000000000000000000000000000000000000000000000F03F0000000000000000" +
+				"0000000000000000000000000000F03F00000000000000000000000000000000",
+			geojson: `{"type":"Polygon","coordinates":[[[0,0],[1,0],[0,1],[0,0]]]}`,
+		},
+	}
+	for _, test := range tests {
+		g, err := parseWKBHex(test.wkb)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		data, _ := json.Marshal(g)
+		if string(data) != test.geojson {
+			t.Errorf("%s: got %s, expected %s", test.name, data, test.geojson)
+		}
+		if g.srid != test.srid {
+			t.Errorf("%s: got SRID %d, expected %d", test.name, g.srid, test.srid)
+		}
+	}
+}
+
+func TestParseWKBHexErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		wkb  string
+	}{
+		{"not hex", "01010000zz"},
+		{"empty", ""},
+		{"byte order", "0201000000000000000000F03F0000000000000040"},
+		{"truncated", "0101000000000000000000F03F"},
+		{"trailing bytes", "0101000000000000000000F03F000000000000004000"},
+		{"unknown type", "0108000000000000000000F03F0000000000000040"},
+		{"member of the wrong type", "010400000001000000010200000000000000"},
+		{"member count beyond the data", "0104000000FFFFFFFF"},
+		{"position count beyond the data", "0102000000FFFFFFFF"},
+		{"infinite point", "0101000000000000000000F07F0000000000000040"},
+		{"point with one NaN ordinate", "0101000000000000000000F87F0000000000000040"},
+		{"line string with a NaN ordinate", "010200000002000000000000000000F03F000000000000F87F00000000000008400000000000001040"},
+		{"line string with an empty position", "010200000002000000000000000000F87F000000000000F87F00000000000008400000000000001040"},
+		{"polygon with an infinite ordinate", "01030000000100000004000000" +
+			"00000000000000000000000000000000" + "000000000000F03F000000000000F0FF" +
+			"000000000000F03F000000000000F03F" + "00000000000000000000000000000000"},
+		{"multi line string with a NaN ordinate", "010500000001000000010200000002000000000000000000F03F000000000000F87F00000000000008400000000000001040"},
+		{"ring of two positions", "0103000000010000000200000000000000000000000000000000000000000000000000F03F000000000000F03F"},
+	}
+	for _, test := range tests {
+		if g, err := parseWKBHex(test.wkb); err == nil {
+			data, _ := json.Marshal(g)
+			t.Errorf("%s: expected an error, got %s", test.name, data)
+		}
+	}
+}
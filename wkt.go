@@ -0,0 +1,354 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// wktGeometryTypes maps the WKT tagged text names to GeoJSON geometry types. Triangles,
+// polyhedral surfaces and TINs have no GeoJSON counterpart and become polygons and multi polygons.
+var wktGeometryTypes = map[string]string{
+	"POINT":              "Point",
+	"LINESTRING":         "LineString",
+	"POLYGON":            "Polygon",
+	"TRIANGLE":           "Polygon",
+	"MULTIPOINT":         "MultiPoint",
+	"MULTILINESTRING":    "MultiLineString",
+	"MULTIPOLYGON":       "MultiPolygon",
+	"POLYHEDRALSURFACE":  "MultiPolygon",
+	"TIN":                "MultiPolygon",
+	"GEOMETRYCOLLECTION": "GeometryCollection",
+}
+
+// parseWKT parses a Well-Known Text geometry, including Z, M and ZM variants and the EWKT
+// SRID=n; prefix. M values are dropped as GeoJSON has no room for them.
+func parseWKT(wkt string) (*Geometry, error) {
+	wkt = strings.TrimSpace(wkt)
+	srid := 0
+	if strings.HasPrefix(strings.ToUpper(wkt), "SRID=") {
+		separator := strings.Index(wkt, ";")
+		if separator < 0 {
+			return nil, errors.New("wkt: SRID prefix without ;")
+		}
+		s, err := strconv.Atoi(wkt[len("SRID="):separator])
+		if err != nil {
+			return nil, fmt.Errorf("wkt: invalid SRID %s", wkt[len("SRID="):separator])
+		}
+		srid = s
+		wkt = wkt[separator+1:]
+	}
+
+	p := &wktParser{tokens: tokenizeWKT(wkt)}
+	g, err := p.parseGeometry()
+	if err != nil {
+		return nil, err
+	}
+	if !p.done() {
+		return nil, fmt.Errorf("wkt: unexpected %s after geometry", p.peek())
+	}
+	g.srid = srid
+	return g, nil
+}
+
+func tokenizeWKT(wkt string) []string {
+	tokens := make([]string, 0)
+	current := strings.Builder{}
+	flush := func() {
+		if current.Len() > 0 {
+			tokens = append(tokens, current.String())
+			current.Reset()
+		}
+	}
+	for _, r := range wkt {
+		switch {
+		case r == '(' || r == ')' || r == ',':
+			flush()
+			tokens = append(tokens, string(r))
+		case unicode.IsSpace(r):
+			flush()
+		default:
+			current.WriteRune(r)
+		}
+	}
+	flush()
+	return tokens
+}
+
+type wktParser struct {
+	tokens []string
+	pos    int
+	// number of ordinates per position and whether the last one is an M value
+	dimensions int
+	hasM       bool
+}
+
+func (p *wktParser) done() bool {
+	return p.pos >= len(p.tokens)
+}
+
+func (p *wktParser) peek() string {
+	if p.done() {
+		return "end of text"
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *wktParser) next() string {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+func (p *wktParser) expect(token string) error {
+	if t := p.next(); t != token {
+		return fmt.Errorf("wkt: expected %s but got %s", token, t)
+	}
+	return nil
+}
+
+func (p *wktParser) parseGeometry() (*Geometry, error) {
+	name := strings.ToUpper(p.next())
+
+	// the dimension can be attached to the type name, as in POINTZ, or be a separate token
+	dimension := ""
+	for _, suffix := range []string{"ZM", "Z", "M"} {
+		if _, known := wktGeometryTypes[name]; !known && strings.HasSuffix(name, suffix) {
+			name = strings.TrimSuffix(name, suffix)
+			dimension = suffix
+			break
+		}
+	}
+	geometryType, known := wktGeometryTypes[name]
+	if !known {
+		return nil, errors.New("wkt: unknown geometry type " + name)
+	}
+	if dimension == "" {
+		switch strings.ToUpper(p.peek()) {
+		case "Z", "M", "ZM":
+			dimension = strings.ToUpper(p.next())
+		}
+	}
+	switch dimension {
+	case "Z":
+		p.dimensions, p.hasM = 3, false
+	case "M":
+		p.dimensions, p.hasM = 3, true
+	case "ZM":
+		p.dimensions, p.hasM = 4, true
+	default:
+		p.dimensions, p.hasM = 0, false
+	}
+
+	g := &Geometry{}
+	g.Type = geometryType
+	g.Coordinates = make([]interface{}, 0)
+
+	if strings.ToUpper(p.peek()) == "EMPTY" {
+		p.next()
+		if geometryType == "GeometryCollection" {
+			g.Geometries = make([]*Geometry, 0)
+		}
+		return g, nil
+	}
+
+	switch geometryType {
+	case "Point":
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		position, err := p.parsePosition()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		for _, c := range position {
+			g.Coordinates = append(g.Coordinates, c)
+		}
+	case "LineString":
+		positions, err := p.parsePositions()
+		if err != nil {
+			return nil, err
+		}
+		for _, position := range positions {
+			g.Coordinates = append(g.Coordinates, position)
+		}
+	case "MultiPoint":
+		positions, err := p.parseMultiPointPositions()
+		if err != nil {
+			return nil, err
+		}
+		for _, position := range positions {
+			g.Coordinates = append(g.Coordinates, position)
+		}
+	case "Polygon":
+		rings, err := p.parseRings()
+		if err != nil {
+			return nil, err
+		}
+		for _, ring := range rings {
+			g.Coordinates = append(g.Coordinates, ring)
+		}
+	case "MultiLineString":
+		lines, err := p.parsePositionLists()
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range lines {
+			g.Coordinates = append(g.Coordinates, line)
+		}
+	case "MultiPolygon":
+		err := p.parseList(func() error {
+			// polyhedral surfaces and TINs may tag their members, as in TRIANGLE((...))
+			if _, known := wktGeometryTypes[strings.ToUpper(p.peek())]; known {
+				p.next()
+			}
+			rings, err := p.parseRings()
+			if err != nil {
+				return err
+			}
+			g.Coordinates = append(g.Coordinates, rings)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	case "GeometryCollection":
+		g.Geometries = make([]*Geometry, 0)
+		err := p.parseList(func() error {
+			member, err := p.parseGeometry()
+			if err != nil {
+				return err
+			}
+			g.Geometries = append(g.Geometries, member)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return g, nil
+}
+
+// parseList parses a parenthesised, comma separated list calling item for each element
+func (p *wktParser) parseList(item func() error) error {
+	if err := p.expect("("); err != nil {
+		return err
+	}
+	for {
+		if err := item(); err != nil {
+			return err
+		}
+		switch t := p.next(); t {
+		case ",":
+			continue
+		case ")":
+			return nil
+		default:
+			return fmt.Errorf("wkt: expected , or ) but got %s", t)
+		}
+	}
+}
+
+// parsePosition reads the ordinates of one position and keeps x, y and z
+func (p *wktParser) parsePosition() ([]float64, error) {
+	ordinates := make([]float64, 0, 4)
+	for {
+		t := p.peek()
+		if t == "," || t == ")" || p.done() {
+			break
+		}
+		f, err := strconv.ParseFloat(t, 64)
+		if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+			// NaN and Inf are parsed as numbers, but cannot be published as GeoJSON
+			return nil, fmt.Errorf("wkt: invalid number %s", t)
+		}
+		ordinates = append(ordinates, f)
+		p.next()
+	}
+
+	dimensions := p.dimensions
+	hasM := p.hasM
+	if dimensions == 0 {
+		// untagged positions: a third ordinate is Z and a fourth is M
+		dimensions = len(ordinates)
+		hasM = dimensions == 4
+	}
+	if len(ordinates) < 2 || len(ordinates) != dimensions || dimensions > 4 {
+		return nil, fmt.Errorf("wkt: invalid position with %d ordinates", len(ordinates))
+	}
+	if hasM {
+		ordinates = ordinates[:len(ordinates)-1]
+	}
+	return ordinates, nil
+}
+
+func (p *wktParser) parsePositions() ([][]float64, error) {
+	positions := make([][]float64, 0)
+	err := p.parseList(func() error {
+		position, err := p.parsePosition()
+		if err != nil {
+			return err
+		}
+		positions = append(positions, position)
+		return nil
+	})
+	return positions, err
+}
+
+// parseMultiPointPositions accepts both MULTIPOINT (1 2, 3 4) and MULTIPOINT ((1 2), (3 4))
+func (p *wktParser) parseMultiPointPositions() ([][]float64, error) {
+	positions := make([][]float64, 0)
+	err := p.parseList(func() error {
+		wrapped := p.peek() == "("
+		if wrapped {
+			p.next()
+		}
+		position, err := p.parsePosition()
+		if err != nil {
+			return err
+		}
+		if wrapped {
+			if err := p.expect(")"); err != nil {
+				return err
+			}
+		}
+		positions = append(positions, position)
+		return nil
+	})
+	return positions, err
+}
+
+func (p *wktParser) parsePositionLists() ([][][]float64, error) {
+	lists := make([][][]float64, 0)
+	err := p.parseList(func() error {
+		positions, err := p.parsePositions()
+		if err != nil {
+			return err
+		}
+		lists = append(lists, positions)
+		return nil
+	})
+	return lists, err
+}
+
+func (p *wktParser) parseRings() ([][][]float64, error) {
+	rings, err := p.parsePositionLists()
+	if err != nil {
+		return nil, err
+	}
+	for i, ring := range rings {
+		ring = closeRing(ring)
+		if len(ring) < 4 {
+			return nil, fmt.Errorf("wkt: a polygon ring needs at least four positions, got %d", len(ring))
+		}
+		rings[i] = ring
+	}
+	windRings(rings)
+	return rings, nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseWKT(t *testing.T) {
+	tests := []struct {
+		wkt     string
+		geojson string
+		srid    int
+	}{
+		{wkt: "POINT (10 60)", geojson: `{"type":"Point","coordinates":[10,60]}`},
+		{wkt: "point(1e1 -6.5E1)", geojson: `{"type":"Point","coordinates":[10,-65]}`},
+		{wkt: "POINT Z (10 60 5)", geojson: `{"type":"Point","coordinates":[10,60,5]}`},
+		{wkt: "POINTZ(10 60 5)", geojson: `{"type":"Point","coordinates":[10,60,5]}`},
+		{wkt: "POINT M (10 60 7)", geojson: `{"type":"Point","coordinates":[10,60]}`},
+		{wkt: "POINT ZM (10 60 5 7)", geojson: `{"type":"Point","coordinates":[10,60,5]}`},
+		{wkt: "SRID=4326;POINT(10 60)", geojson: `{"type":"Point","coordinates":[10,60]}`, srid: 4326},
+		{wkt: "LINESTRING (30 10, 10 30, 40 40)", geojson: `{"type":"LineString","coordinates":[[30,10],[10,30],[40,40]]}`},
+		{
+			wkt:     "POLYGON ((35 10, 45 45, 15 40, 10 20, 35 10), (20 30, 35 35, 30 20, 20 30))",
+			geojson: `{"type":"Polygon","coordinates":[[[35,10],[45,45],[15,40],[10,20],[35,10]],[[20,30],[35,35],[30,20],[20,30]]]}`,
+		},
+		// rings are turned to follow the right-hand rule
+		{
+			wkt:     "POLYGON ((0 0, 0 10, 10 10, 10 0, 0 0), (2 2, 4 2, 4 4, 2 2))",
+			geojson: `{"type":"Polygon","coordinates":[[[0,0],[10,0],[10,10],[0,10],[0,0]],[[2,2],[4,4],[4,2],[2,2]]]}`,
+		},
+		{wkt: "MULTIPOINT ((10 40), (40 30))", geojson: `{"type":"MultiPoint","coordinates":[[10,40],[40,30]]}`},
+		{wkt: "MULTIPOINT (10 40, 40 30)", geojson: `{"type":"MultiPoint","coordinates":[[10,40],[40,30]]}`},
+		{
+			wkt:     "MULTILINESTRING ((10 10, 20 20), (40 40, 30 30))",
+			geojson: `{"type":"MultiLineString","coordinates":[[[10,10],[20,20]],[[40,40],[30,30]]]}`,
+		},
+		{
+			wkt:     "MULTIPOLYGON (((30 20, 45 40, 10 40, 30 20)), ((15 5, 40 10, 10 20, 5 10, 15 5)))",
+			geojson: `{"type":"MultiPolygon","coordinates":[[[[30,20],[45,40],[10,40],[30,20]]],[[[15,5],[40,10],[10,20],[5,10],[15,5]]]]}`,
+		},
+		{
+			wkt:     "GEOMETRYCOLLECTION (POINT (40 10), LINESTRING (10 10, 20 20))",
+			geojson: `{"type":"GeometryCollection","geometries":[{"type":"Point","coordinates":[40,10]},{"type":"LineString","coordinates":[[10,10],[20,20]]}]}`,
+		},
+		{wkt: "TRIANGLE ((0 0, 0 1, 1 0, 0 0))", geojson: `{"type":"Polygon","coordinates":[[[0,0],[1,0],[0,1],[0,0]]]}`},
+		{
+			wkt:     "TIN (((0 0, 0 1, 1 0, 0 0)), ((1 0, 0 1, 1 1, 1 0)))",
+			geojson: `{"type":"MultiPolygon","coordinates":[[[[0,0],[1,0],[0,1],[0,0]]],[[[1,0],[1,1],[0,1],[1,0]]]]}`,
+		},
+		{
+			wkt:     "POLYHEDRALSURFACE Z (((0 0 0, 0 1 0, 1 1 0, 0 0 0)))",
+			geojson: `{"type":"MultiPolygon","coordinates":[[[[0,0,0],[1,1,0],[0,1,0],[0,0,0]]]]}`,
+		},
+		{wkt: "POINT EMPTY", geojson: `{"type":"Point","coordinates":[]}`},
+		{wkt: "LINESTRING Z EMPTY", geojson: `{"type":"LineString","coordinates":[]}`},
+		{wkt: "GEOMETRYCOLLECTION EMPTY", geojson: `{"type":"GeometryCollection","geometries":[]}`},
+	}
+	for _, test := range tests {
+		g, err := parseWKT(test.wkt)
+		if err != nil {
+			t.Errorf("%s: %v", test.wkt, err)
+			continue
+		}
+		data, _ := json.Marshal(g)
+		if string(data) != test.geojson {
+			t.Errorf("%s: got %s, expected %s", test.wkt, data, test.geojson)
+		}
+		if g.srid != test.srid {
+			t.Errorf("%s: got SRID %d, expected %d", test.wkt, g.srid, test.srid)
+		}
+	}
+}
+
+func TestParseWKTErrors(t *testing.T) {
+	tests := []string{
+		"",
+		"CIRCLE (1 2)",
+		"POINT (10)",
+		"POINT Z (1 2)",
+		"POINT (10 60",
+		"POINT (10 60) x",
+		"LINESTRING (1 2, a b)",
+		"POINT (NaN 1)",
+		"POINT (Inf 0)",
+		"POINT Z (1 2 -Infinity)",
+		"POINT M (1 2 NaN)",
+		"LINESTRING (1 2, 3 1e999)",
+		"POLYGON ((0 0, 1 0, 1 nan, 0 0))",
+		"SRID=x;POINT(1 2)",
+		"SRID=4326 POINT(1 2)",
+	}
+	for _, wkt := range tests {
+		if g, err := parseWKT(wkt); err == nil {
+			data, _ := json.Marshal(g)
+			t.Errorf("%q: expected an error, got %s", wkt, data)
+		}
+	}
+}